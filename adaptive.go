@@ -0,0 +1,114 @@
+package geerpc
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptiveLimitOption configures the adaptive concurrency limit of a Server, zero fields take the defaults.
+// The limit grows while the latency of the requests stays close to its long-term average, and
+// shrinks as soon as it rises, so that requests are shed before they queue up and time out.
+type AdaptiveLimitOption struct {
+	InitialLimit int     // default 20
+	MinLimit     int     // default 4
+	MaxLimit     int     // default 1000
+	Smoothing    float64 // how fast the limit moves to a new estimate, between 0 and 1, default 0.2
+	Tolerance    float64 // how much the latency may rise before the limit shrinks, default 1.5
+}
+
+// adaptiveLimiter 梯度限流：比较短期和长期的平均延迟，
+// 延迟升高时按比例降低并发上限，延迟稳定时缓慢增加
+type adaptiveLimiter struct {
+	opt      AdaptiveLimitOption
+	mu       sync.Mutex // protect following
+	limit    float64
+	inflight int
+	shortRTT float64 // 最近请求的平均延迟，纳秒
+	longRTT  float64 // 长期平均延迟，纳秒
+}
+
+const (
+	shortRTTWeight = 0.2
+	longRTTWeight  = 0.02
+)
+
+func newAdaptiveLimiter(opt *AdaptiveLimitOption) *adaptiveLimiter {
+	if opt == nil {
+		return nil
+	}
+	l := &adaptiveLimiter{opt: *opt}
+	if l.opt.MinLimit <= 0 {
+		l.opt.MinLimit = 4
+	}
+	if l.opt.MaxLimit <= 0 {
+		l.opt.MaxLimit = 1000
+	}
+	if l.opt.MaxLimit < l.opt.MinLimit {
+		l.opt.MaxLimit = l.opt.MinLimit
+	}
+	if l.opt.InitialLimit <= 0 {
+		l.opt.InitialLimit = 20
+	}
+	if l.opt.Smoothing <= 0 || l.opt.Smoothing > 1 {
+		l.opt.Smoothing = 0.2
+	}
+	if l.opt.Tolerance < 1 {
+		l.opt.Tolerance = 1.5
+	}
+	l.limit = l.clamp(float64(l.opt.InitialLimit))
+	return l
+}
+
+func (l *adaptiveLimiter) clamp(limit float64) float64 {
+	return math.Min(math.Max(limit, float64(l.opt.MinLimit)), float64(l.opt.MaxLimit))
+}
+
+// acquire reports whether a request may run, it must then be finished by release
+func (l *adaptiveLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight >= int(l.limit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// release finishes a request which took rtt, sample is false if rtt says nothing
+// about the load of the server, for example the client canceled the call
+func (l *adaptiveLimiter) release(rtt time.Duration, sample bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inflight := l.inflight
+	l.inflight--
+	if !sample || rtt <= 0 {
+		return
+	}
+	r := float64(rtt)
+	if l.longRTT == 0 {
+		l.shortRTT, l.longRTT = r, r
+	} else {
+		l.shortRTT += (r - l.shortRTT) * shortRTTWeight
+		l.longRTT += (r - l.longRTT) * longRTTWeight
+	}
+	// 负载下降之后，长期延迟要尽快跟上，否则上限会一直偏大
+	if l.longRTT > 2*l.shortRTT {
+		l.longRTT *= 0.95
+	}
+	// 并发远低于上限时延迟说明不了上限是否合适，不调整
+	if float64(inflight) < l.limit/2 {
+		return
+	}
+	gradient := math.Min(math.Max(l.opt.Tolerance*l.longRTT/l.shortRTT, 0.5), 1)
+	// 梯度为1时增加sqrt(limit)，给排队留出余量
+	next := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = l.clamp(l.limit*(1-l.opt.Smoothing) + next*l.opt.Smoothing)
+}
+
+// current returns the current limit
+func (l *adaptiveLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
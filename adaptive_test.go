@@ -0,0 +1,75 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fillLimit 占满limiter，再让所有请求以rtt的延迟结束
+func fillLimit(l *adaptiveLimiter, rtt time.Duration) {
+	n := 0
+	for l.acquire() {
+		n++
+	}
+	for i := 0; i < n; i++ {
+		l.release(rtt, true)
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := newAdaptiveLimiter(&AdaptiveLimitOption{InitialLimit: 10, MinLimit: 2, MaxLimit: 100})
+	// 一次只有一个请求时不调整上限
+	for i := 0; i < 100; i++ {
+		_assert(l.acquire(), "expect the request to run")
+		l.release(10*time.Millisecond, true)
+	}
+	_assert(l.current() == 10, "expect the limit to stay at 10, got %d", l.current())
+
+	for i := 0; i < 10; i++ {
+		fillLimit(l, 10*time.Millisecond)
+	}
+	grown := l.current()
+	_assert(grown > 10, "expect the limit to grow while the latency is steady, got %d", grown)
+
+	fillLimit(l, 100*time.Millisecond)
+	_assert(l.current() < grown, "expect the limit to shrink when the latency rises, got %d from %d", l.current(), grown)
+
+	// 取消的请求只归还名额，不影响上限
+	limit, n := l.current(), 0
+	for l.acquire() {
+		n++
+	}
+	for i := 0; i < n; i++ {
+		l.release(time.Hour, false)
+	}
+	_assert(l.current() == limit && l.acquire(), "expect canceled requests to leave the limit at %d, got %d", limit, l.current())
+}
+
+func TestServer_adaptiveLimit(t *testing.T) {
+	s := NewServer(&ServerOption{AdaptiveLimit: &AdaptiveLimitOption{InitialLimit: 2, MinLimit: 2, MaxLimit: 2}})
+	_ = s.Register(new(Gauge))
+	client, _ := Dial("tcp", startTestServer(s))
+	defer func() { _ = client.Close() }()
+
+	ch := make(chan []error)
+	go func() { ch <- holdAll(client, 2, 200) }()
+	time.Sleep(50 * time.Millisecond)
+	var reply int
+	err := client.Call(context.Background(), "Gauge.Hold", 0, &reply)
+	_assert(errors.Is(err, ErrOverloaded), "expect ErrOverloaded above the limit, got %v", err)
+	_assert(countOverloaded(<-ch) == 0, "expect the calls within the limit to succeed")
+	err = client.Call(context.Background(), "Gauge.Hold", 0, &reply)
+	_assert(err == nil, "expect the call to run once the load is gone: %v", err)
+
+	// 超时的方法返回之前仍然算在并发里
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	go func() { ch <- holdAll(client, 1, 200) }()
+	err = client.Call(ctx, "Gauge.Hold", 200, &reply)
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect DeadlineExceeded, got %v", err)
+	err = client.Call(context.Background(), "Gauge.Hold", 0, &reply)
+	_assert(errors.Is(err, ErrOverloaded), "expect the timed out call to hold its place, got %v", err)
+	_assert(countOverloaded(<-ch) == 0, "expect the other call to succeed")
+}
@@ -0,0 +1,217 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"geerpc/coder"
+	"geerpc/status"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator checks the credentials of a request, such as its metadata or the
+// certificate of its Peer, and returns who sent it. It returns an error to reject
+// the request, which is sent to the client as Unauthenticated unless it is a *status.Status.
+type Authenticator func(ctx context.Context, h *coder.Header) (principal string, err error)
+
+// Authorizer returns an error if principal is not allowed to call serviceMethod,
+// it is sent to the client as PermissionDenied unless it is a *status.Status.
+type Authorizer func(ctx context.Context, principal, serviceMethod string) error
+
+// Credentials returns the metadata carrying the credentials of a call, it is set
+// by Option.Credentials and applies to every call and stream of the client
+type Credentials func(serviceMethod string) (Metadata, error)
+
+type principalKey struct{}
+
+// PrincipalFromContext returns who sent the request, as returned by the Authenticator
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	p, ok := ctx.Value(principalKey{}).(string)
+	return p, ok
+}
+
+// Auth returns an interceptor that authenticates every request with authn, then
+// checks it with authz. A nil authz allows every authenticated principal.
+// The server accepts every request until it is added by Server.Use.
+func Auth(authn Authenticator, authz Authorizer) ServerInterceptor {
+	return func(ctx context.Context, h *coder.Header, argv, reply interface{}, next Handler) error {
+		principal, err := authn(ctx, h)
+		if err != nil {
+			return withCode(err, status.Unauthenticated)
+		}
+		if authz != nil {
+			if err = authz(ctx, principal, h.ServiceMethod); err != nil {
+				return withCode(err, status.PermissionDenied)
+			}
+		}
+		return next(context.WithValue(ctx, principalKey{}, principal), h, argv, reply)
+	}
+}
+
+// withCode 没有状态码的错误使用code
+func withCode(err error, code status.Code) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.New(code, err.Error())
+}
+
+// ACL maps a principal to the methods it may call. A method is written as
+// "Service.Method", "Service.*" or "*", and the principal "*" applies to everyone.
+type ACL map[string][]string
+
+// Authorize is an Authorizer allowing only the methods listed in the ACL
+func (acl ACL) Authorize(_ context.Context, principal, serviceMethod string) error {
+	if acl.allows(acl[principal], serviceMethod) || acl.allows(acl["*"], serviceMethod) {
+		return nil
+	}
+	return status.Errorf(status.PermissionDenied, "rpc server: %s is not allowed to call %s", principal, serviceMethod)
+}
+
+func (acl ACL) allows(patterns []string, serviceMethod string) bool {
+	service := serviceMethod[:strings.LastIndex(serviceMethod, ".")+1]
+	for _, p := range patterns {
+		if p == "*" || p == serviceMethod || (service != "" && p == service+"*") {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	authorizationKey = "authorization"
+	hmacKeyIDKey     = "hmac-key-id"
+	hmacTimestampKey = "hmac-timestamp"
+	hmacNonceKey     = "hmac-nonce"
+	hmacSignatureKey = "hmac-signature"
+)
+
+// BearerToken returns Credentials sending token in the "authorization" metadata
+func BearerToken(token string) Credentials {
+	return func(string) (Metadata, error) {
+		return Metadata{authorizationKey: "Bearer " + token}, nil
+	}
+}
+
+// BearerTokenAuthenticator authenticates the token sent by BearerToken with verify,
+// which returns the principal the token belongs to
+func BearerTokenAuthenticator(verify func(token string) (principal string, err error)) Authenticator {
+	return func(ctx context.Context, h *coder.Header) (string, error) {
+		token, ok := strings.CutPrefix(h.Metadata[authorizationKey], "Bearer ")
+		if !ok || token == "" {
+			return "", status.New(status.Unauthenticated, "rpc server: missing bearer token")
+		}
+		return verify(token)
+	}
+}
+
+// HMACCredentials signs every call with key, the server checks the signature
+// with the same key found by keyID. The signature covers the key id, the method,
+// the time and a random nonce, but not the arguments of the call.
+func HMACCredentials(keyID string, key []byte) Credentials {
+	return func(serviceMethod string) (Metadata, error) {
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		ts, nonce := strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(b[:])
+		return Metadata{
+			hmacKeyIDKey:     keyID,
+			hmacTimestampKey: ts,
+			hmacNonceKey:     nonce,
+			hmacSignatureKey: hmacSign(key, keyID, serviceMethod, ts, nonce),
+		}, nil
+	}
+}
+
+// HMACAuthenticator checks the signature sent by HMACCredentials with the key of
+// its key id, and returns the key id as the principal. A signature older than
+// maxSkew is rejected, and a signature is accepted only once within maxSkew, so
+// a captured request can't be replayed to this authenticator. The arguments are
+// not signed, use TLS when the requests may be changed on their way.
+func HMACAuthenticator(keys map[string][]byte, maxSkew time.Duration) Authenticator {
+	nonces := newNonceCache(maxSkew)
+	return func(ctx context.Context, h *coder.Header) (string, error) {
+		keyID, ts, sig := h.Metadata[hmacKeyIDKey], h.Metadata[hmacTimestampKey], h.Metadata[hmacSignatureKey]
+		nonce := h.Metadata[hmacNonceKey]
+		key, ok := keys[keyID]
+		if !ok {
+			return "", status.Errorf(status.Unauthenticated, "rpc server: unknown hmac key %q", keyID)
+		}
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return "", status.New(status.Unauthenticated, "rpc server: invalid hmac timestamp")
+		}
+		signed := time.Unix(sec, 0)
+		if skew := time.Since(signed); skew > maxSkew || skew < -maxSkew {
+			return "", status.New(status.Unauthenticated, "rpc server: hmac signature expired")
+		}
+		if nonce == "" {
+			return "", status.New(status.Unauthenticated, "rpc server: missing hmac nonce")
+		}
+		if !hmac.Equal([]byte(sig), []byte(hmacSign(key, keyID, h.ServiceMethod, ts, nonce))) {
+			return "", status.New(status.Unauthenticated, "rpc server: invalid hmac signature")
+		}
+		// 签名验证通过之后才记录nonce，伪造的请求不会占用内存
+		if !nonces.add(keyID+"\n"+nonce, signed.Add(maxSkew)) {
+			return "", status.New(status.Unauthenticated, "rpc server: hmac signature replayed")
+		}
+		return keyID, nil
+	}
+}
+
+func hmacSign(key []byte, keyID, serviceMethod, ts, nonce string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(keyID + "\n" + serviceMethod + "\n" + ts + "\n" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nonceCache 记录maxSkew之内用过的nonce，过期的nonce对应的签名已经因为时间被拒绝
+type nonceCache struct {
+	maxSkew   time.Duration
+	mu        sync.Mutex           // protect following
+	seen      map[string]time.Time // nonce -> expiry
+	lastSweep time.Time
+}
+
+func newNonceCache(maxSkew time.Duration) *nonceCache {
+	return &nonceCache{maxSkew: maxSkew, seen: make(map[string]time.Time), lastSweep: time.Now()}
+}
+
+// add records nonce until expiry, it returns false if nonce was already recorded
+func (nc *nonceCache) add(nonce string, expiry time.Time) bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	now := time.Now()
+	if now.Sub(nc.lastSweep) > nc.maxSkew {
+		for n, exp := range nc.seen {
+			if now.After(exp) {
+				delete(nc.seen, n)
+			}
+		}
+		nc.lastSweep = now
+	}
+	if _, ok := nc.seen[nonce]; ok {
+		return false
+	}
+	nc.seen[nonce] = expiry
+	return true
+}
+
+// CertificateAuthenticator returns the common name of the verified client
+// certificate as the principal, see Server.AcceptTLS
+func CertificateAuthenticator() Authenticator {
+	return func(ctx context.Context, _ *coder.Header) (string, error) {
+		if p, ok := PeerFromContext(ctx); ok {
+			if cert := p.Certificate(); cert != nil {
+				return cert.Subject.CommonName, nil
+			}
+		}
+		return "", status.New(status.Unauthenticated, "rpc server: no verified client certificate")
+	}
+}
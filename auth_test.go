@@ -0,0 +1,129 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"geerpc/coder"
+	"geerpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+type Account int
+
+// Whoami replies the principal of the request
+func (a Account) Whoami(ctx context.Context, _ int, reply *string) error {
+	*reply, _ = PrincipalFromContext(ctx)
+	return nil
+}
+
+// Delete is only allowed to admins
+func (a Account) Delete(_ int, _ *int) error {
+	return nil
+}
+
+func TestServer_Auth(t *testing.T) {
+	var account Account
+	var foo Foo
+	s := NewServer()
+	_ = s.Register(&account)
+	_ = s.Register(&foo)
+	tokens := map[string]string{"t-alice": "alice", "t-root": "root"}
+	s.Use(Auth(BearerTokenAuthenticator(func(token string) (string, error) {
+		if p, ok := tokens[token]; ok {
+			return p, nil
+		}
+		return "", errors.New("unknown token")
+	}), ACL{
+		"root": {"*"},
+		"*":    {"Account.Whoami", "Foo.*"},
+	}.Authorize))
+	addr := startTestServer(s)
+
+	dial := func(creds Credentials) *Client {
+		client, _ := Dial("tcp", addr, &Option{Credentials: creds})
+		t.Cleanup(func() { _ = client.Close() })
+		return client
+	}
+	var name string
+	var n int
+	alice, root := dial(BearerToken("t-alice")), dial(BearerToken("t-root"))
+	err := alice.Call(context.Background(), "Account.Whoami", 0, &name)
+	_assert(err == nil && name == "alice", "expect principal alice, got %q, %v", name, err)
+	err = alice.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &n)
+	_assert(err == nil && n == 3, "expect Foo.* to be allowed: %v", err)
+	err = alice.Call(context.Background(), "Account.Delete", 0, &n)
+	_assert(errors.Is(err, status.PermissionDenied), "expect PermissionDenied, got %v", err)
+	err = root.Call(context.Background(), "Account.Delete", 0, &n)
+	_assert(err == nil, "expect root to be allowed: %v", err)
+
+	err = dial(nil).Call(context.Background(), "Account.Whoami", 0, &name)
+	_assert(errors.Is(err, status.Unauthenticated), "expect Unauthenticated without token, got %v", err)
+	err = dial(BearerToken("t-eve")).Call(context.Background(), "Account.Whoami", 0, &name)
+	_assert(errors.Is(err, status.Unauthenticated), "expect Unauthenticated for a bad token, got %v", err)
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	authn := HMACAuthenticator(map[string][]byte{"k1": []byte("secret")}, time.Minute)
+	sign := func(keyID, key, method string) *coder.Header {
+		md, _ := HMACCredentials(keyID, []byte(key))(method)
+		return &coder.Header{ServiceMethod: method, Metadata: md}
+	}
+	p, err := authn(context.Background(), sign("k1", "secret", "Foo.Sum"))
+	_assert(err == nil && p == "k1", "expect principal k1, got %q, %v", p, err)
+	_, err = authn(context.Background(), sign("k1", "wrong", "Foo.Sum"))
+	_assert(errors.Is(err, status.Unauthenticated), "expect a wrong key to fail, got %v", err)
+	_, err = authn(context.Background(), sign("k2", "secret", "Foo.Sum"))
+	_assert(errors.Is(err, status.Unauthenticated), "expect an unknown key id to fail, got %v", err)
+	// 签名绑定了方法名，不能用到别的方法上
+	h := sign("k1", "secret", "Foo.Sum")
+	h.ServiceMethod = "Account.Delete"
+	_, err = authn(context.Background(), h)
+	_assert(errors.Is(err, status.Unauthenticated), "expect the signature of another method to fail, got %v", err)
+	h = sign("k1", "secret", "Foo.Sum")
+	h.Metadata[hmacTimestampKey] = "1"
+	_, err = authn(context.Background(), h)
+	_assert(errors.Is(err, status.Unauthenticated), "expect an old signature to fail, got %v", err)
+	// 同一个签名只能用一次
+	h = sign("k1", "secret", "Foo.Sum")
+	_, err = authn(context.Background(), h)
+	_assert(err == nil, "expect the signature to be accepted: %v", err)
+	_, err = authn(context.Background(), h)
+	_assert(errors.Is(err, status.Unauthenticated), "expect a replayed signature to fail, got %v", err)
+	h = sign("k1", "secret", "Foo.Sum")
+	h.Metadata[hmacNonceKey] = "other"
+	_, err = authn(context.Background(), h)
+	_assert(errors.Is(err, status.Unauthenticated), "expect a changed nonce to fail, got %v", err)
+}
+
+func TestCertificateAuthenticator(t *testing.T) {
+	ca := newTestCA(t)
+	var account Account
+	s := NewServer()
+	_ = s.Register(&account)
+	s.Use(Auth(CertificateAuthenticator(), nil))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.AcceptTLS(l, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    ca.pool,
+	})
+	defer func() { _ = l.Close() }()
+
+	var name string
+	client, _ := Dial("tcp", l.Addr().String(), &Option{TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "bob", x509.ExtKeyUsageClientAuth)},
+		RootCAs:      ca.pool,
+	}})
+	defer func() { _ = client.Close() }()
+	err := client.Call(context.Background(), "Account.Whoami", 0, &name)
+	_assert(err == nil && name == "bob", "expect principal bob, got %q, %v", name, err)
+
+	anonymous, _ := Dial("tcp", l.Addr().String(), &Option{TLSConfig: &tls.Config{RootCAs: ca.pool}})
+	defer func() { _ = anonymous.Close() }()
+	err = anonymous.Call(context.Background(), "Account.Whoami", 0, &name)
+	_assert(errors.Is(err, status.Unauthenticated), "expect Unauthenticated without certificate, got %v", err)
+}
@@ -3,10 +3,12 @@ package geerpc
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"geerpc/coder"
+	"geerpc/status"
 	"io"
 	"log"
 	"net"
@@ -20,12 +22,17 @@ import (
 
 // Call represents an active RPC
 type Call struct {
-	Seq           uint64      // 请求的序号
-	ServiceMethod string      // 请求的服务名和方法名 例如 "Foo.Sum"
-	Args          interface{} // 请求的参数
-	Reply         interface{} // 请求的返回值
-	Error         error       // 请求的错误
-	Done          chan *Call  // 请求完成后会调用Done
+	Seq           uint64          // 请求的序号
+	ServiceMethod string          // 请求的服务名和方法名 例如 "Foo.Sum"
+	Args          interface{}     // 请求的参数
+	Reply         interface{}     // 请求的返回值
+	Error         error           // 请求的错误
+	Done          chan *Call      // 请求完成后会调用Done
+	Metadata      Metadata        // 随请求发送的metadata
+	ReplyMetadata Metadata        // 服务端在响应中带回的metadata
+	deadline      time.Time       // 调用方ctx的deadline，随请求发给服务端
+	ctx           context.Context // 重新连接时排队的call等到ctx结束，nil表示一直等待
+	stream        *ClientStream   // 流式调用收到的消息交给它
 }
 
 // 把call自己传给done是为什么呢？ 为了让调用者知道哪个call已经完成了
@@ -38,16 +45,25 @@ func (c *Call) done() {
 type Client struct {
 	cc       coder.Coder      // 用于发送请求
 	opt      *Option          // 选项
-	sending  sync.Mutex       // protect following
-	header   coder.Header     // 请求的header
+	sending  sync.RWMutex     // held for reading while sending a message, go away waits for them
 	mu       sync.Mutex       // protect following
 	seq      uint64           // 请求的序号
 	pending  map[uint64]*Call // 存储未处理完的call
 	closing  bool             // 用户主动关闭
 	shutdown bool             // 服务端关闭
+	draining bool             // 服务端要求不再发送新的请求
+
+	interceptors []ClientInterceptor // 拦截器，protected by mu
+
+	redial      func() (coder.Coder, error) // 连接断开后重新连接，nil表示不重连，protected by mu
+	reconnected chan struct{}               // 正在等待新的连接时不为nil，连上之后关闭，protected by mu
 }
 
-var ErrShutdown = errors.New("connection is shut down")
+var ErrShutdown error = status.New(status.Unavailable, "connection is shut down")
+
+// ErrGoAway is returned for calls made after the server asked the client to stop
+// sending new requests, the calls are never sent and can be retried on another server
+var ErrGoAway error = status.New(status.Unavailable, "server is going away")
 
 func (c *Client) Close() error {
 	c.mu.Lock()
@@ -56,6 +72,15 @@ func (c *Client) Close() error {
 		return ErrShutdown
 	}
 	c.closing = true
+	// 唤醒等待重新连接的call和重连的goroutine
+	if c.reconnected != nil {
+		close(c.reconnected)
+		c.reconnected = nil
+		// 旧的连接已经关闭
+		if c.shutdown {
+			return nil
+		}
+	}
 	return c.cc.Close() // 关闭连接
 }
 
@@ -63,7 +88,23 @@ func (c *Client) Close() error {
 func (c *Client) IsAvailable() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return !c.closing && !c.shutdown
+	return !c.closing && !c.shutdown && !c.draining
+}
+
+// GoingAway returns true if the server asked the client to stop sending new requests.
+// The calls already sent still finish, and the client closes itself after the server does.
+func (c *Client) GoingAway() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.draining
+}
+
+// Pending returns the number of calls and streams waiting for their replies,
+// it tells how busy the connection is
+func (c *Client) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
 }
 
 // registerCall registers a call
@@ -73,6 +114,9 @@ func (c *Client) registerCall(call *Call) (uint64, error) {
 	if c.closing || c.shutdown {
 		return 0, ErrShutdown
 	}
+	if c.draining {
+		return 0, ErrGoAway
+	}
 	// 生成序号
 	call.Seq = c.seq
 	c.seq++
@@ -93,6 +137,16 @@ func (c *Client) removeCall(seq uint64) *Call {
 	return call
 }
 
+// streamOf returns the stream of the pending call with seq, nil if there is none
+func (c *Client) streamOf(seq uint64) *ClientStream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call := c.pending[seq]; call != nil {
+		return call.stream
+	}
+	return nil
+}
+
 // terminateCalls terminates all pending calls
 func (c *Client) terminateCalls(err error) {
 	// 为什么这里要保护header？
@@ -108,55 +162,132 @@ func (c *Client) terminateCalls(err error) {
 		call.Error = err
 		call.done()
 	}
+	// 重新连接之后从空的pending开始
+	c.pending = make(map[uint64]*Call)
+	// 新的call在这之后就按照重连的策略等待
+	if c.redial != nil && !c.closing && c.reconnected == nil {
+		c.reconnected = make(chan struct{})
+	}
 }
 
-func (c *Client) receive() {
+// receive reads the replies on cc until the connection breaks
+func (c *Client) receive(cc coder.Coder) {
 	var err error
 	for err == nil {
 		var h coder.Header
 		// 读取header
-		if err = c.cc.ReadHeader(&h); err != nil {
+		if err = cc.ReadHeader(&h); err != nil {
+			// 太大的响应已经被丢弃，只结束它所属的call
+			if errors.Is(err, coder.ErrMsgTooLarge) {
+				c.tooLarge(h.Seq, err)
+				err = nil
+				continue
+			}
+			// 损坏的帧不知道属于哪个call，跳过它
+			if errors.Is(err, coder.ErrInvalidFrame) {
+				log.Println("rpc client: skip frame:", err)
+				err = nil
+				continue
+			}
 			break
 		}
+		if h.Kind == coder.KindGoAway {
+			if err = cc.ReadBody(nil); err == nil {
+				c.goAway()
+			}
+			continue
+		}
+		// 流式调用的消息，call还没有结束，先不解码body
+		if h.Kind == coder.KindStream {
+			var raw coder.RawBody
+			if err = cc.ReadBody(&raw); err == nil {
+				if st := c.streamOf(h.Seq); st != nil {
+					st.push(&raw)
+				} else {
+					raw.Discard()
+				}
+			}
+			continue
+		}
 		// 服务端已经处理完成，客户端接收到了header就可以删除call了
 		call := c.removeCall(h.Seq)
+		if call != nil {
+			call.ReplyMetadata = h.Metadata
+		}
 		switch {
 		case call == nil: // call 不存在，可能是请求没有发送完整，或者因为其他原因被取消，但是服务端仍旧处理了
-			err = c.cc.ReadBody(nil)
-		case h.Error != "": // call 存在，但服务端处理出错，即 h.Error 不为空
-			call.Error = errors.New(h.Error)
-			err = c.cc.ReadBody(nil)
+			err = cc.ReadBody(nil)
+		case h.Code != uint32(status.OK) || h.Error != "": // call 存在，但服务端处理出错，还原出服务端的状态
+			call.Error = headerError(&h)
+			err = cc.ReadBody(nil)
 			call.done()
 		default: // call 存在，服务端处理正常，那么需要从 body 中读取 Reply 的值。
-			err = c.cc.ReadBody(call.Reply)
+			err = cc.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = errors.New("reading body " + err.Error())
+				call.Error = status.Errorf(status.Internal, "reading body %v", err)
 			}
 			call.done()
 		}
+		// 只是这一帧解码失败，连接仍然可用
+		if errors.Is(err, coder.ErrInvalidFrame) {
+			err = nil
+		}
 	}
 	// 出错了，需要通知所有call
 	c.terminateCalls(err)
+	if reconnected := c.startReconnect(); reconnected != nil {
+		_ = cc.Close()
+		c.reconnect(reconnected)
+		return
+	}
+	// 服务端排空后关闭了连接，没有人会再用这个client，释放连接
+	if c.GoingAway() {
+		_ = cc.Close()
+	}
 }
 
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
-	coderFunc := coder.NewCoderFuncMap[opt.CoderType]
+	cc, err := clientHandshake(conn, opt)
+	if err != nil {
+		return nil, err
+	}
+	return newClientCoder(cc, opt), nil
+}
+
+// clientHandshake 发送Option并等待服务端确认，返回收发消息的coder
+func clientHandshake(conn net.Conn, opt *Option) (coder.Coder, error) {
+	coderFunc, ok := coder.Get(opt.CoderType)
 	// coder不存在
-	if coderFunc == nil {
+	if !ok {
 		err := fmt.Errorf("invalid coder type %s", opt.CoderType)
 		log.Println("rpc client: coder error:", err)
 		return nil, err
 	}
 
 	// send options
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
+	if err := writeJSON(conn, opt); err != nil {
 		log.Println("rpc client: options error:", err)
 		_ = conn.Close()
 		return nil, err
 	}
-	// coderFunc 是一个NewCoderFunc的实例。调用它就返回一个Coder
-	cc := coderFunc(conn)
-	return newClientCoder(cc, opt), nil
+	// 等待服务端的握手回复，确认coder被接受后才能发送请求
+	var hs handshake
+	if err := json.NewDecoder(byteReader{conn}).Decode(&hs); err != nil {
+		log.Println("rpc client: handshake error:", err)
+		_ = conn.Close()
+		return nil, err
+	}
+	if hs.Error != "" {
+		_ = conn.Close()
+		return nil, errors.New("rpc client: handshake refused: " + hs.Error)
+	}
+	if hs.CoderType != opt.CoderType {
+		_ = conn.Close()
+		return nil, fmt.Errorf("rpc client: handshake: server accepted coder %s, expect %s", hs.CoderType, opt.CoderType)
+	}
+	// coderFunc 是一个NewCoderFunc的实例，每个消息由它编码后放进一个帧里，
+	// 两端使用服务端回复的限制
+	return coder.NewFrameCoder(conn, coderFunc, opt.frameOption().Stricter(hs.frameOption())), nil
 }
 
 func newClientCoder(cc coder.Coder, opt *Option) *Client {
@@ -165,9 +296,11 @@ func newClientCoder(cc coder.Coder, opt *Option) *Client {
 		cc:      cc,                     // 用于发送请求
 		opt:     opt,                    // 选项
 		pending: make(map[uint64]*Call), // 存储未处理完的call
+		// 复制一份，之后Use不会影响opt
+		interceptors: append([]ClientInterceptor(nil), opt.Interceptors...),
 	}
 	// 开启一个goroutine来接收响应
-	go client.receive()
+	go client.receive(cc)
 	return client
 }
 
@@ -209,8 +342,19 @@ func dialTimeout(newClient newClientFunc, network, address string, opts ...*Opti
 			_ = conn.Close()
 		}
 	}()
-	ch := make(chan clientResult)
+	// 带缓冲，超时之后握手的goroutine也能退出
+	ch := make(chan clientResult, 1)
 	go func() {
+		conn := conn
+		// TLS握手也算在连接超时里
+		if opt.TLSConfig != nil {
+			tlsConn := tls.Client(conn, clientTLSConfig(opt.TLSConfig, address))
+			if err := tlsConn.Handshake(); err != nil {
+				ch <- clientResult{nil, err}
+				return
+			}
+			conn = tlsConn
+		}
 		client, err := newClient(conn, opt)
 		ch <- clientResult{client, err}
 	}()
@@ -230,39 +374,150 @@ func dialTimeout(newClient newClientFunc, network, address string, opts ...*Opti
 
 // Dial connects to an RPC server at the specified network address
 func Dial(network, address string, opts ...*Option) (client *Client, err error) {
-	return dialTimeout(NewClient, network, address, opts...)
+	client, err = dialTimeout(NewClient, network, address, opts...)
+	return withReconnect(client, err, clientHandshake, network, address)
 }
 
-// send sends a request
-func (c *Client) send(call *Call) {
-	// 保护header不被修改
-	c.sending.Lock()
-	defer c.sending.Unlock()
+// send sends a request, the error is reported through call as well
+func (c *Client) send(call *Call) error {
+	// 正在重新连接时按照Option.Reconnect的策略等待，等待时不能占着sending
+	if err := c.awaitConnected(call.ctx); err != nil {
+		call.Error = err
+		call.done()
+		return err
+	}
+	// 请求可以同时发送，大的请求会被分成多个帧，和其他请求交替发送
+	c.sending.RLock()
+	defer c.sending.RUnlock()
+	// 凭证放在metadata里随请求发送
+	md, err := c.credentials(call)
+	if err != nil {
+		call.Error = err
+		call.done()
+		return err
+	}
 	// 注册call
 	seq, err := c.registerCall(call)
 	// 注册失败，直接返回
 	if err != nil {
 		call.Error = err
 		call.done()
-		return
+		return err
 	}
 	// 设置header
-	c.header.ServiceMethod = call.ServiceMethod
-	c.header.Seq = seq
-	c.header.Error = ""
+	header := &coder.Header{
+		ServiceMethod: call.ServiceMethod,
+		Seq:           seq,
+		Metadata:      md,
+	}
+	if !call.deadline.IsZero() {
+		// 至少1ns，0表示没有deadline
+		if header.Timeout = time.Until(call.deadline); header.Timeout <= 0 {
+			header.Timeout = 1
+		}
+	}
 	// 写入header 和 args
-	if err := c.cc.Write(&c.header, call.Args); err != nil {
+	if err := c.cc.Write(header, call.Args); err != nil {
+		err = sendError(err)
 		// 写入失败，移除call
 		call := c.removeCall(seq)
 		if call != nil {
 			call.Error = err
 			call.done()
 		}
+		return err
+	}
+	return nil
+}
+
+// credentials returns the metadata of call with the pairs of Option.Credentials added
+func (c *Client) credentials(call *Call) (Metadata, error) {
+	if c.opt.Credentials == nil {
+		return call.Metadata, nil
+	}
+	creds, err := c.opt.Credentials(call.ServiceMethod)
+	if err != nil {
+		return nil, status.Errorf(status.Unauthenticated, "rpc client: credentials: %v", err)
+	}
+	md := call.Metadata.Copy()
+	if md == nil {
+		md = make(Metadata, len(creds))
+	}
+	for k, v := range creds {
+		md[k] = v
+	}
+	return md, nil
+}
+
+// goAway stops sending new requests and acknowledges the server,
+// the calls already sent still get their replies
+func (c *Client) goAway() {
+	c.mu.Lock()
+	c.draining = true
+	// 服务端关闭连接之后重新连接，新的call按照策略等待
+	if c.redial != nil && c.reconnected == nil {
+		c.reconnected = make(chan struct{})
+	}
+	c.mu.Unlock()
+	// 拿到sending锁之后，之前注册的call都已经发送完了，确认消息是最后一个
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	h := coder.Header{Kind: coder.KindGoAway}
+	if err := c.cc.Write(&h, invalidRequest); err != nil {
+		log.Println("rpc client: send go away error:", err)
+	}
+}
+
+// tooLarge ends the call with seq whose reply is larger than MaxRecvMsgSize
+func (c *Client) tooLarge(seq uint64, err error) {
+	call := c.removeCall(seq)
+	if call == nil {
+		return
+	}
+	call.Error = status.Errorf(status.ResourceExhausted, "rpc client: reply: %v", err)
+	call.done()
+	// 流的服务端可能还在发送，让它停下来；不在读取连接的goroutine里写
+	if call.stream != nil {
+		go c.sendCancel(seq)
+	}
+}
+
+// write sends a message that isn't a new call, such as a cancel or a message on a stream
+func (c *Client) write(h *coder.Header, body interface{}) error {
+	c.sending.RLock()
+	defer c.sending.RUnlock()
+	return c.cc.Write(h, body)
+}
+
+// sendCancel tells the server to give up the call with seq
+func (c *Client) sendCancel(seq uint64) {
+	if err := c.write(&coder.Header{Seq: seq, Kind: coder.KindCancel}, invalidRequest); err != nil {
+		log.Println("rpc client: send cancel error:", err)
 	}
 }
 
 // Go invokes the function asynchronously
 func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	invoker := c.invoker()
+	if invoker == nil {
+		return c.goCall(serviceMethod, args, reply, done)
+	}
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+	}
+	// 有拦截器时，在新的goroutine里走完整个拦截器链
+	go func() {
+		call.Error = invoker(context.Background(), serviceMethod, args, reply)
+		call.done()
+	}()
+	return call
+}
+
+// goCall 生成call并且异步发送，不经过拦截器
+func (c *Client) goCall(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
 	// 生成call
 	call := &Call{
 		ServiceMethod: serviceMethod,
@@ -270,6 +525,11 @@ func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Ca
 		Reply:         reply,
 		Done:          done,
 	}
+	// 排队等待重新连接时不阻塞调用方
+	if c.queueing() {
+		go c.send(call)
+		return call
+	}
 	// 发送call
 	c.send(call)
 	return call
@@ -277,16 +537,37 @@ func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Ca
 
 // Call invokes the named function, waits for it to complete, and returns its error status
 func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	if invoker := c.invoker(); invoker != nil {
+		return invoker(ctx, serviceMethod, args, reply)
+	}
+	return c.invoke(ctx, serviceMethod, args, reply)
+}
+
+// invoke 发送请求并等待响应，是拦截器链的最后一环
+func (c *Client) invoke(ctx context.Context, serviceMethod string, args, reply interface{}) error {
 	// 生成call，注册call，并且异步发送call
-	call := c.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+		Metadata:      outgoingMetadata(ctx),
+		ctx:           ctx,
+	}
+	call.deadline, _ = ctx.Deadline()
+	c.send(call)
 	// 等待call完成
 	select {
 	// ctx.Done()返回的是一个channel，如果这个channel被关闭了，那么就会执行case <-ctx.Done()
 	case <-ctx.Done():
-		c.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		// 请求还没有响应，通知服务端不用再处理了
+		if c.removeCall(call.Seq) != nil {
+			c.sendCancel(call.Seq)
+		}
+		return status.Errorf(status.CodeOf(ctx.Err()), "rpc client: call failed: %v", ctx.Err())
 	// call.Done返回的是一个channel，如果这个channel被关闭了，那么就会执行case call := <-call.Done
 	case call := <-call.Done:
+		saveResponseMetadata(ctx, call.ReplyMetadata)
 		return call.Error
 	}
 }
@@ -297,12 +578,21 @@ func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply int
 // client: receive -> removeCall -> done -> Call
 
 func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
+	cc, err := httpHandshake(conn, opt)
+	if err != nil {
+		return nil, err
+	}
+	return newClientCoder(cc, opt), nil
+}
+
+// httpHandshake 先通过CONNECT切换到RPC协议，再和NewClient一样握手
+func httpHandshake(conn net.Conn, opt *Option) (coder.Coder, error) {
 	// send options
 	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", defaultRPCPath))
 	// read response
 	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
 	if err == nil && resp.Status == connected {
-		return NewClient(conn, opt)
+		return clientHandshake(conn, opt)
 	}
 	if err == nil {
 		err = errors.New("unexpected HTTP response: " + resp.Status)
@@ -313,9 +603,16 @@ func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
 // DialHTTP connects to an HTTP RPC server at the specified network address
 // listening on the default HTTP RPC path.
 func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
-	return dialTimeout(NewHTTPClient, network, address, opts...)
+	client, err := dialTimeout(NewHTTPClient, network, address, opts...)
+	return withReconnect(client, err, httpHandshake, network, address)
 }
 
+// XDial calls different functions to connect to a RPC server
+// according the first parameter rpcAddr.
+// rpcAddr is a general format (protocol@addr) to represent a rpc server
+// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock,
+// tls@10.0.0.1:9999 which verifies the server with opt.TLSConfig, or the
+// system roots if it is nil
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
@@ -325,6 +622,17 @@ func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	switch network {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
+	case "tls":
+		opt, err := parseOptions(opts...)
+		if err != nil {
+			return nil, err
+		}
+		if opt.TLSConfig == nil {
+			tlsOpt := *opt
+			tlsOpt.TLSConfig = &tls.Config{}
+			opt = &tlsOpt
+		}
+		return Dial("tcp", addr, opt)
 	default:
 		return Dial(network, addr, opts...)
 	}
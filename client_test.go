@@ -2,8 +2,12 @@ package geerpc
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"geerpc/coder"
 	"net"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -74,7 +78,8 @@ func TestClientCall(t *testing.T) {
 	time.Sleep(time.Second)
 	t.Run("client timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 		var reply int
 		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
 		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect a timeout error")
@@ -89,3 +94,57 @@ func TestClientCall(t *testing.T) {
 		_assert(err != nil && strings.Contains(err.Error(), "handle request timeout"), "expect a timeout error!!!")
 	})
 }
+
+func TestClient_handshake(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+
+	t.Run("json coder", func(t *testing.T) {
+		client, err := Dial("tcp", addr, &Option{CoderType: coder.JsonType})
+		_assert(err == nil, "failed to dial with json coder: %v", err)
+		defer func() { _ = client.Close() }()
+		var reply int
+		err = client.Call(context.Background(), "Bar.Timeout", 1, &reply)
+		_assert(err == nil, "failed to call with json coder: %v", err)
+	})
+	t.Run("unknown coder", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = conn.Close() }()
+		_ = writeJSON(conn, &Option{MagicNumber: MagicNUmber, CoderType: "application/unknown"})
+		var hs handshake
+		err = json.NewDecoder(conn).Decode(&hs)
+		_assert(err == nil && strings.Contains(hs.Error, "invalid coder type"), "expect a handshake error")
+	})
+}
+
+func TestClient_Use(t *testing.T) {
+	t.Parallel()
+	var foo Foo
+	s := NewServer()
+	_ = s.Register(&foo)
+	addr := startTestServer(s)
+
+	var calls int32
+	count := func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker) error {
+		atomic.AddInt32(&calls, 1)
+		return invoker(ctx, serviceMethod, args, reply)
+	}
+	client, _ := Dial("tcp", addr, &Option{Interceptors: []ClientInterceptor{count}})
+	defer func() { _ = client.Close() }()
+	client.Use(func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker) error {
+		if args.(*Args).Num1 < 0 {
+			return errors.New("negative number")
+		}
+		return invoker(ctx, serviceMethod, args, reply)
+	})
+
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call through interceptors: %v", err)
+	call := <-client.Go("Foo.Sum", &Args{Num1: -1, Num2: 2}, &reply, make(chan *Call, 1)).Done
+	_assert(call.Error != nil && call.Error.Error() == "negative number", "expect Go to be short-circuited")
+	_assert(atomic.LoadInt32(&calls) == 2, "expect 2 intercepted calls, got %d", calls)
+}
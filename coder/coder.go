@@ -1,11 +1,32 @@
 package coder
 
-import "io"
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kind tells what a message is for
+type Kind uint8
+
+const (
+	KindCall      Kind = iota // a request or its response
+	KindCancel                // the client gives up the call with the same Seq
+	KindGoAway                // the server is going away, the client replies with it after its last request
+	KindStream                // a message on the stream opened by the call with the same Seq
+	KindCloseSend             // the client has sent its last message on the stream with the same Seq
+)
 
 type Header struct {
-	ServiceMethod string // format "Service.Method"
-	Seq           uint64 // sequence number chosen by client
-	Error         string
+	ServiceMethod string            // format "Service.Method"
+	Seq           uint64            // sequence number chosen by client
+	Error         string            // error message of the response
+	Code          uint32            // status code of the response, 0 means OK
+	Details       []string          // optional details of the error
+	Metadata      map[string]string // key-value pairs of the request or the response
+	Kind          Kind              // KindCall if unset
+	Timeout       time.Duration     // time left before the caller's deadline, 0 means none
 }
 
 // Coder 编码器接口
@@ -25,9 +46,46 @@ const (
 	JsonType Type = "application/json"
 )
 
-var NewCoderFuncMap map[Type]NewCoderFunc
+var mu sync.RWMutex // protect NewCoderFuncMap
+
+// NewCoderFuncMap holds the registered coders, Register, Get and Types read and fill it.
+//
+// Deprecated: use Register and Get, the map must not be used while coders are registered
+// or looked up concurrently.
+var NewCoderFuncMap = make(map[Type]NewCoderFunc)
 
 func init() {
-	NewCoderFuncMap = make(map[Type]NewCoderFunc)
-	NewCoderFuncMap[GobType] = NewGobCoder
+	Register(GobType, NewGobCoder)
+	Register(JsonType, NewJsonCoder)
+}
+
+// Register makes a coder available under the given type, registering
+// the same type again replaces the previous one. It is safe for concurrent use.
+func Register(t Type, f NewCoderFunc) {
+	if f == nil {
+		panic("rpc coder: Register coder is nil")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	NewCoderFuncMap[t] = f
+}
+
+// Get returns the NewCoderFunc registered under the given type
+func Get(t Type) (NewCoderFunc, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	f, ok := NewCoderFuncMap[t]
+	return f, ok
+}
+
+// Types returns the sorted list of registered coder types
+func Types() []Type {
+	mu.RLock()
+	defer mu.RUnlock()
+	types := make([]Type, 0, len(NewCoderFuncMap))
+	for t := range NewCoderFuncMap {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
 }
@@ -0,0 +1,747 @@
+package coder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// FrameType identifies what a frame carries
+type FrameType byte
+
+const (
+	FrameMessage      FrameType = iota + 1 // a whole header/body pair encoded by a Coder, not flow controlled
+	FrameData                              // a chunk of a header/body pair, see dataHeaderLen
+	FrameWindowUpdate                      // credit given back to the sender, see windowUpdateLen
+)
+
+// Frame Format:
+// | type (1 byte) | length (4 bytes, big endian) | payload (length bytes) |
+const frameHeaderLen = 5
+
+// Payload of FrameData:
+// | seq (8 bytes) | flags (1 byte) | chunk |
+// the chunks of a message are sent in order, the last one has flagEnd set.
+// A message larger than MaxRecvMsgSize is replaced by a single frame with
+// flagTooLarge set, whose chunk is the size of the message (8 bytes).
+const (
+	dataHeaderLen = 9
+	flagEnd       = 0x1
+	flagTooLarge  = 0x2
+)
+
+// Payload of FrameWindowUpdate:
+// | seq (8 bytes) | increment (4 bytes) |
+// seq 0 gives credit back to the connection
+const windowUpdateLen = 12
+
+const (
+	DefaultWindowSize     = 64 << 10 // 64KB
+	DefaultConnWindowSize = 5 << 20  // 5MB, room for a message of DefaultMaxRecvMsgSize
+	DefaultMaxFrameSize   = 16 << 10 // 16KB
+	DefaultMaxRecvMsgSize = 4 << 20  // 4MB
+	DefaultMaxSendMsgSize = math.MaxInt32
+)
+
+// ErrInvalidFrame is returned when the payload of a frame can't be decoded,
+// the connection stays usable and the next frame can be read as usual.
+var ErrInvalidFrame = errors.New("rpc coder: invalid frame")
+
+// ErrMsgTooLarge is returned when a message is larger than the limits of FrameOption.
+// Nothing is sent for a message too large to send, and only its size is sent for a
+// message too large to receive, so the connection stays usable in both cases.
+var ErrMsgTooLarge = errors.New("rpc coder: message too large")
+
+// ErrFlowControl is returned when the peer sends more than its connection credit,
+// the FrameCoder is closed.
+var ErrFlowControl = errors.New("rpc coder: flow control violated")
+
+// Framer reads and writes length-prefixed frames on a connection
+type Framer struct {
+	conn io.ReadWriteCloser
+	max  uint32 // max payload length of the frames read, 0 means no limit
+	r    *bufio.Reader
+	rbuf [frameHeaderLen]byte
+	mu   sync.Mutex // protect following
+	w    *bufio.Writer
+	wbuf [frameHeaderLen]byte
+}
+
+func NewFramer(conn io.ReadWriteCloser) *Framer {
+	return &Framer{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+// ReadFrame reads the next frame, it must not be called concurrently
+func (f *Framer) ReadFrame() (FrameType, []byte, error) {
+	if _, err := io.ReadFull(f.r, f.rbuf[:]); err != nil {
+		return 0, nil, err
+	}
+	typ := FrameType(f.rbuf[0])
+	n := binary.BigEndian.Uint32(f.rbuf[1:])
+	// 帧的长度不可信，超过上限时不分配内存，连接已经无法继续读取
+	if f.max > 0 && n > f.max {
+		return 0, nil, fmt.Errorf("rpc coder: frame of %d bytes is larger than the limit %d", n, f.max)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(f.r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return typ, payload, nil
+}
+
+// WriteFrame writes a whole frame whose payload is the concatenation of parts and
+// flushes it to the connection
+func (f *Framer) WriteFrame(typ FrameType, parts ...[]byte) error {
+	var n uint64
+	for _, p := range parts {
+		n += uint64(len(p))
+	}
+	if n > uint64(^uint32(0)) {
+		return fmt.Errorf("rpc coder: frame too large: %d bytes", n)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.wbuf[0] = byte(typ)
+	binary.BigEndian.PutUint32(f.wbuf[1:], uint32(n))
+	if _, err := f.w.Write(f.wbuf[:]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := f.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return f.w.Flush()
+}
+
+func (f *Framer) Close() error {
+	return f.conn.Close()
+}
+
+// bufferConn lets a Coder work on the payload of a single frame
+type bufferConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (bufferConn) Close() error { return nil }
+
+// FrameOption configures the flow control and the message size limits of a FrameCoder,
+// zero fields take the defaults. Both ends of a connection must use the same values.
+type FrameOption struct {
+	WindowSize     uint32 // bytes of stream messages a stream may have sent but not decoded by the receiver
+	ConnWindowSize uint32 // bytes of unfinished messages a connection may have sent, raised to 5/4 of MaxRecvMsgSize
+	MaxFrameSize   uint32 // larger messages are split into several frames
+	MaxRecvMsgSize int    // encoded size of the largest message accepted
+	MaxSendMsgSize int    // encoded size of the largest message sent
+}
+
+func (opt FrameOption) withDefaults() FrameOption {
+	if opt.WindowSize == 0 {
+		opt.WindowSize = DefaultWindowSize
+	}
+	if opt.ConnWindowSize == 0 {
+		opt.ConnWindowSize = DefaultConnWindowSize
+	}
+	if opt.MaxFrameSize == 0 {
+		opt.MaxFrameSize = DefaultMaxFrameSize
+	}
+	if opt.MaxRecvMsgSize == 0 {
+		opt.MaxRecvMsgSize = DefaultMaxRecvMsgSize
+	}
+	if opt.MaxSendMsgSize == 0 {
+		opt.MaxSendMsgSize = DefaultMaxSendMsgSize
+	}
+	if opt.MaxRecvMsgSize > math.MaxInt32 {
+		opt.MaxRecvMsgSize = math.MaxInt32
+	}
+	// 一个消息拿到全部额度之后才开始发送，窗口要能放下最大的消息和接收方攒着没有归还的额度
+	if least := uint32((opt.MaxRecvMsgSize + 3) / 4 * 5); opt.ConnWindowSize < least {
+		opt.ConnWindowSize = least
+	}
+	return opt
+}
+
+// Stricter returns the smaller value of each field of opt and other, after their zero
+// fields take the defaults. A server applies it to the options of a client and its own.
+func (opt FrameOption) Stricter(other FrameOption) FrameOption {
+	opt, other = opt.withDefaults(), other.withDefaults()
+	if other.WindowSize < opt.WindowSize {
+		opt.WindowSize = other.WindowSize
+	}
+	if other.ConnWindowSize < opt.ConnWindowSize {
+		opt.ConnWindowSize = other.ConnWindowSize
+	}
+	if other.MaxFrameSize < opt.MaxFrameSize {
+		opt.MaxFrameSize = other.MaxFrameSize
+	}
+	if other.MaxRecvMsgSize < opt.MaxRecvMsgSize {
+		opt.MaxRecvMsgSize = other.MaxRecvMsgSize
+	}
+	if other.MaxSendMsgSize < opt.MaxSendMsgSize {
+		opt.MaxSendMsgSize = other.MaxSendMsgSize
+	}
+	return opt
+}
+
+// updateAt 返回接收方攒着的连接额度归还的阈值，攒着的额度加上最大的消息不超过窗口
+func (opt FrameOption) updateAt() uint32 {
+	n := opt.ConnWindowSize / 4
+	if rest := opt.ConnWindowSize - uint32(opt.MaxRecvMsgSize); rest < n {
+		n = rest
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// FrameCoder puts every header/body pair written by the inner Coder into frames.
+// Each message is decoded on its own, so a corrupt message is rejected alone and
+// an unwanted body is skipped without being decoded.
+//
+// The cost is that each message is encoded by a new inner Coder: a gob message carries
+// the descriptors of its types every time, so a small call like Foo.Sum with an int takes
+// about 190 bytes instead of 19 on a gob stream. A single stream per direction can't be
+// used, as the messages don't arrive in the order they were encoded and an undecoded body
+// would leave its types out of the stream. JsonType has no such overhead.
+//
+// Large messages are split into frames of at most MaxFrameSize, and the calls with
+// messages to send take turns writing one frame each, so a large message doesn't
+// hold up the others.
+// The receiver gives back the connection credit of a message once it has read the
+// whole message, and a message takes the credit for its whole size before its first
+// frame, so the receiver never buffers more than ConnWindowSize bytes of unfinished
+// messages and the messages that started can always finish.
+// A stream (messages of Kind KindStream with the same Seq) can't send more once it
+// has WindowSize bytes not yet decoded by the receiver, see WaitWindow.
+// Write can be called concurrently.
+type FrameCoder struct {
+	framer   *Framer
+	newCoder NewCoderFunc
+	opt      FrameOption
+	closed   chan struct{}
+	once     sync.Once
+	kick     chan struct{} // 有新的window update要发送
+	wake     chan struct{} // 有新的消息要发送
+
+	// 以下字段只在ReadHeader和ReadBody里使用
+	body        Coder                  // decodes the body of the message read by ReadHeader
+	release     func()                 // gives back the stream credit of that message, nil if none
+	partial     map[uint64]*partialMsg // messages whose chunks are still arriving
+	buffered    int                    // bytes of the unfinished messages in partial
+	connPending uint32                 // connection credit not given back yet
+
+	mu         sync.Mutex // protect following
+	connWindow int64
+	connReady  chan struct{} // closed when connWindow grows
+	streams    map[uint64]*streamWindow
+	sendq      map[uint64][]*outMsg // messages waiting to be written, by seq
+	turns      []uint64             // seqs with messages waiting, in the order they write a frame
+	updates    map[uint64]uint32    // window updates to send, seq 0 is the connection
+}
+
+// partialMsg is a message whose chunks are still arriving
+type partialMsg struct {
+	data    []byte
+	size    int
+	dropped bool // the message is too large, its data is no longer kept
+}
+
+// streamWindow is the send credit of a stream, a stream with all its credit has none
+type streamWindow struct {
+	avail int64
+	ready chan struct{} // closed when avail becomes positive
+}
+
+// outMsg is a message waiting for writeLoop to write its chunks
+type outMsg struct {
+	data    []byte // the part not written yet
+	flags   byte
+	started bool // the message has its connection credit
+	done    chan error
+}
+
+var _ Coder = (*FrameCoder)(nil)
+
+// NewFrameCoder 把conn包装成一个分帧的coder，帧内的数据由newCoder编解码，
+// opts为空时使用默认的流量控制窗口
+func NewFrameCoder(conn io.ReadWriteCloser, newCoder NewCoderFunc, opts ...FrameOption) *FrameCoder {
+	var opt FrameOption
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	opt = opt.withDefaults()
+	c := &FrameCoder{
+		framer:     NewFramer(conn),
+		newCoder:   newCoder,
+		opt:        opt,
+		closed:     make(chan struct{}),
+		kick:       make(chan struct{}, 1),
+		wake:       make(chan struct{}, 1),
+		partial:    make(map[uint64]*partialMsg),
+		connWindow: int64(opt.ConnWindowSize),
+		streams:    make(map[uint64]*streamWindow),
+		sendq:      make(map[uint64][]*outMsg),
+		updates:    make(map[uint64]uint32),
+	}
+	// 控制消息不分帧，但它们都很小
+	c.framer.max = opt.MaxFrameSize + dataHeaderLen
+	go c.sendWindowUpdates()
+	go c.writeLoop()
+	return c
+}
+
+func (c *FrameCoder) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.framer.Close()
+}
+
+// ReadHeader 读取下一个完整的消息并解码其中的Header，未知类型的帧直接跳过。
+// 消息超过MaxRecvMsgSize时返回ErrMsgTooLarge，h中只有Seq，之后不需要调用ReadBody
+func (c *FrameCoder) ReadHeader(h *Header) error {
+	c.body, c.release = nil, nil
+	for {
+		typ, payload, err := c.framer.ReadFrame()
+		if err != nil {
+			return err
+		}
+		var msg []byte
+		data := false
+		var seq uint64
+		switch typ {
+		case FrameMessage:
+			msg = payload
+		case FrameData:
+			if len(payload) < dataHeaderLen {
+				return fmt.Errorf("%w: data frame too short", ErrInvalidFrame)
+			}
+			seq = binary.BigEndian.Uint64(payload)
+			flags := payload[8]
+			chunk := payload[dataHeaderLen:]
+			if flags&flagTooLarge != 0 {
+				if len(chunk) != 8 {
+					return fmt.Errorf("%w: bad size of a message too large", ErrInvalidFrame)
+				}
+				*h = Header{Seq: seq}
+				return fmt.Errorf("%w: peer has %d bytes to send, limit %d", ErrMsgTooLarge, binary.BigEndian.Uint64(chunk), c.opt.MaxRecvMsgSize)
+			}
+			p := c.partial[seq]
+			if p == nil && flags&flagEnd != 0 {
+				// 只有一个帧的消息读到就完整了
+				c.giveBackConn(uint32(len(chunk)))
+				if len(chunk) > c.opt.MaxRecvMsgSize {
+					*h = Header{Seq: seq}
+					return fmt.Errorf("%w: received %d bytes, limit %d", ErrMsgTooLarge, len(chunk), c.opt.MaxRecvMsgSize)
+				}
+				msg, data = chunk, true
+				break
+			}
+			if p == nil {
+				// 空的帧不占额度，不能让它们开出没有上限的消息
+				if len(chunk) == 0 {
+					return c.violate("empty chunk")
+				}
+				p = new(partialMsg)
+				c.partial[seq] = p
+			}
+			if err = c.addChunk(p, chunk); err != nil {
+				return err
+			}
+			if flags&flagEnd == 0 {
+				continue
+			}
+			delete(c.partial, seq)
+			if p.dropped {
+				*h = Header{Seq: seq}
+				return fmt.Errorf("%w: received %d bytes, limit %d", ErrMsgTooLarge, p.size, c.opt.MaxRecvMsgSize)
+			}
+			c.buffered -= len(p.data)
+			c.giveBackConn(uint32(len(p.data)))
+			msg, data = p.data, true
+		case FrameWindowUpdate:
+			if len(payload) < windowUpdateLen {
+				return fmt.Errorf("%w: window update frame too short", ErrInvalidFrame)
+			}
+			c.addCredit(binary.BigEndian.Uint64(payload), binary.BigEndian.Uint32(payload[8:]))
+			continue
+		default:
+			continue
+		}
+		dec := c.newCoder(bufferConn{Reader: bytes.NewReader(msg)})
+		if err = dec.ReadHeader(h); err != nil {
+			return fmt.Errorf("%w: header: %v", ErrInvalidFrame, err)
+		}
+		c.body = dec
+		// 对端发来了调用的结果或者取消了调用，这个流不会再发送消息
+		if h.Kind == KindCall || h.Kind == KindCancel {
+			c.endStream(h.Seq)
+		}
+		// 流上的消息被解码之后才归还流的额度
+		if data && h.Kind == KindStream {
+			n := uint32(len(msg))
+			c.release = func() { c.windowUpdate(seq, n) }
+		}
+		return nil
+	}
+}
+
+// addChunk 把chunk加到p后面，消息完成或者被丢弃之后才归还它的连接额度。
+// 遵守流量控制的对端不会让缓存的数据超过ConnWindowSize，超过时只能关闭连接
+func (c *FrameCoder) addChunk(p *partialMsg, chunk []byte) error {
+	p.size += len(chunk)
+	if p.dropped {
+		c.giveBackConn(uint32(len(chunk)))
+		return nil
+	}
+	if p.size > c.opt.MaxRecvMsgSize {
+		// 超过上限的消息不再保存，已经缓存的和之后到达的数据直接归还额度
+		c.buffered -= len(p.data)
+		c.giveBackConn(uint32(len(p.data) + len(chunk)))
+		p.data, p.dropped = nil, true
+		return nil
+	}
+	p.data = append(p.data, chunk...)
+	if c.buffered += len(chunk); c.buffered > int(c.opt.ConnWindowSize) {
+		return c.violate(fmt.Sprintf("%d bytes of unfinished messages, window %d", c.buffered, c.opt.ConnWindowSize))
+	}
+	return nil
+}
+
+// violate 关闭不遵守流量控制的连接
+func (c *FrameCoder) violate(reason string) error {
+	_ = c.Close()
+	return fmt.Errorf("%w: %s", ErrFlowControl, reason)
+}
+
+// RawBody keeps the body of a frame undecoded when passed to FrameCoder.ReadBody,
+// so that it can be decoded later by whoever knows its type
+type RawBody struct {
+	dec     Coder
+	release func()
+}
+
+// Decode decodes the body into v, it can only be called once
+func (b *RawBody) Decode(v interface{}) error {
+	dec := b.dec
+	b.dec = nil
+	if dec == nil {
+		return fmt.Errorf("%w: body already decoded", ErrInvalidFrame)
+	}
+	b.Discard()
+	if err := dec.ReadBody(v); err != nil {
+		return fmt.Errorf("%w: body: %v", ErrInvalidFrame, err)
+	}
+	return nil
+}
+
+// Discard drops the body without decoding it. A stream message that is never
+// decoded must be discarded, or its stream can't get its flow control credit back.
+func (b *RawBody) Discard() {
+	b.dec = nil
+	if b.release != nil {
+		b.release()
+		b.release = nil
+	}
+}
+
+// ReadBody 解码当前消息中的Body，body为nil时直接丢弃，body为*RawBody时留到之后再解码
+func (c *FrameCoder) ReadBody(body interface{}) error {
+	dec, release := c.body, c.release
+	c.body, c.release = nil, nil
+	if dec == nil {
+		return fmt.Errorf("%w: no header read before body", ErrInvalidFrame)
+	}
+	if raw, ok := body.(*RawBody); ok {
+		raw.dec, raw.release = dec, release
+		return nil
+	}
+	if release != nil {
+		release()
+	}
+	if body == nil {
+		return nil
+	}
+	if err := dec.ReadBody(body); err != nil {
+		return fmt.Errorf("%w: body: %v", ErrInvalidFrame, err)
+	}
+	return nil
+}
+
+// Write 把Header和Body编码之后交给writeLoop分帧发送，等到最后一个帧写出去再返回
+func (c *FrameCoder) Write(h *Header, body interface{}) error {
+	var buf bytes.Buffer
+	if err := c.newCoder(bufferConn{Writer: &buf}).Write(h, body); err != nil {
+		return err
+	}
+	msg := buf.Bytes()
+	if len(msg) > c.opt.MaxSendMsgSize {
+		return fmt.Errorf("%w: sending %d bytes, limit %d", ErrMsgTooLarge, len(msg), c.opt.MaxSendMsgSize)
+	}
+	// 调用的结果、取消和CloseSend之后，这一端不会再在流上发送消息
+	if h.Kind == KindCall || h.Kind == KindCancel || h.Kind == KindCloseSend {
+		c.endStream(h.Seq)
+	}
+	// 控制消息很小，不受流量控制，否则取消消息可能被它要取消的流卡住
+	if h.Kind == KindCancel || h.Kind == KindGoAway || h.Kind == KindCloseSend {
+		return c.writeFrame(FrameMessage, msg)
+	}
+
+	if h.Kind == KindStream {
+		c.takeStream(h.Seq, len(msg))
+	}
+	m := &outMsg{data: msg, done: make(chan error, 1)}
+	// 对端收不下的消息只发送它的大小，不占用额度
+	if len(msg) > c.opt.MaxRecvMsgSize {
+		m.data = binary.BigEndian.AppendUint64(nil, uint64(len(msg)))
+		m.flags, m.started = flagTooLarge, true
+	}
+	c.mu.Lock()
+	// 同一个seq的消息排在一个队列里，前一个写完才轮到下一个
+	q := c.sendq[h.Seq]
+	if len(q) == 0 {
+		c.turns = append(c.turns, h.Seq)
+	}
+	c.sendq[h.Seq] = append(q, m)
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case err := <-m.done:
+		return err
+	case <-c.closed:
+		select {
+		case err := <-m.done:
+			return err
+		default:
+			return io.ErrClosedPipe
+		}
+	}
+}
+
+// writeLoop 按turns的顺序轮流写出每个seq队首消息的一个帧，写完一个帧的seq排到最后，
+// 所以正在发送的消息不管多大，每一轮都只占用一个帧
+func (c *FrameCoder) writeLoop() {
+	var prefix [dataHeaderLen]byte
+	for {
+		c.mu.Lock()
+		i, m := c.nextMsg()
+		if m == nil {
+			// 没有消息，或者排在前面的消息在等连接的额度
+			if c.connReady == nil {
+				c.connReady = make(chan struct{})
+			}
+			ready := c.connReady
+			c.mu.Unlock()
+			select {
+			case <-c.wake:
+			case <-ready:
+			case <-c.closed:
+				return
+			}
+			continue
+		}
+		seq := c.turns[i]
+		c.mu.Unlock()
+
+		n := len(m.data)
+		if n > int(c.opt.MaxFrameSize) && m.flags&flagTooLarge == 0 {
+			n = int(c.opt.MaxFrameSize)
+		}
+		binary.BigEndian.PutUint64(prefix[:], seq)
+		prefix[8] = m.flags
+		if n == len(m.data) {
+			prefix[8] |= flagEnd
+		}
+		if err := c.writeFrame(FrameData, prefix[:], m.data[:n]); err != nil {
+			m.done <- err
+			return
+		}
+		m.data = m.data[n:]
+
+		c.mu.Lock()
+		c.turns = append(c.turns[:i], c.turns[i+1:]...)
+		if len(m.data) > 0 {
+			c.turns = append(c.turns, seq)
+		} else {
+			m.done <- nil
+			q := c.sendq[seq]
+			q[0] = nil
+			if q = q[1:]; len(q) == 0 {
+				delete(c.sendq, seq)
+			} else {
+				c.sendq[seq] = q
+				c.turns = append(c.turns, seq)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// nextMsg 返回下一个写帧的消息和它的seq在turns里的位置，调用时持有c.mu。
+// 还没开始的消息按排队的顺序拿走全部大小的连接额度，前面的消息拿不到时后面的也不能插队，
+// 这样大消息不会一直等下去
+func (c *FrameCoder) nextMsg() (int, *outMsg) {
+	waiting := false
+	for i, seq := range c.turns {
+		m := c.sendq[seq][0]
+		if !m.started {
+			if waiting || int64(len(m.data)) > c.connWindow {
+				waiting = true
+				continue
+			}
+			c.connWindow -= int64(len(m.data))
+			m.started = true
+		}
+		return i, m
+	}
+	return -1, nil
+}
+
+// writeFrame 写了一半的帧会让整个连接错位，只能关闭连接
+func (c *FrameCoder) writeFrame(typ FrameType, parts ...[]byte) error {
+	if err := c.framer.WriteFrame(typ, parts...); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
+
+// WaitWindow blocks until the stream with seq is allowed to send another message,
+// or ctx is done. Write doesn't wait for the stream credit by itself, so that a
+// sender stuck on a stream the receiver no longer reads can be stopped by ctx.
+func (c *FrameCoder) WaitWindow(ctx context.Context, seq uint64) error {
+	for {
+		c.mu.Lock()
+		w := c.streams[seq]
+		if w == nil || w.avail > 0 {
+			c.mu.Unlock()
+			return nil
+		}
+		if w.ready == nil {
+			w.ready = make(chan struct{})
+		}
+		ready := w.ready
+		c.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closed:
+			return io.ErrClosedPipe
+		}
+	}
+}
+
+// takeStream 从流的额度里扣除一个消息的大小，额度可以被扣成负数，
+// 这样比窗口还大的消息也能发送出去
+func (c *FrameCoder) takeStream(seq uint64, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := c.streams[seq]
+	if w == nil {
+		w = &streamWindow{avail: int64(c.opt.WindowSize)}
+		c.streams[seq] = w
+	}
+	w.avail -= int64(n)
+}
+
+// endStream 删掉流的额度，被放弃的流还没有归还的额度不会再回来，
+// 等待这个流的WaitWindow直接返回
+func (c *FrameCoder) endStream(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := c.streams[seq]
+	if w == nil {
+		return
+	}
+	if w.ready != nil {
+		close(w.ready)
+	}
+	delete(c.streams, seq)
+}
+
+// addCredit 收到对端的window update，归还额度并唤醒等待的发送方
+func (c *FrameCoder) addCredit(seq uint64, n uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq == 0 {
+		c.connWindow += int64(n)
+		if c.connReady != nil {
+			close(c.connReady)
+			c.connReady = nil
+		}
+		return
+	}
+	w := c.streams[seq]
+	if w == nil {
+		return
+	}
+	w.avail += int64(n)
+	if w.avail > 0 && w.ready != nil {
+		close(w.ready)
+		w.ready = nil
+	}
+	// 额度全部归还的流不需要再记录
+	if w.avail >= int64(c.opt.WindowSize) {
+		delete(c.streams, seq)
+	}
+}
+
+// giveBackConn 攒够一定数量再归还连接的额度，减少window update的数量
+func (c *FrameCoder) giveBackConn(n uint32) {
+	c.connPending += n
+	if c.connPending >= c.opt.updateAt() {
+		c.windowUpdate(0, c.connPending)
+		c.connPending = 0
+	}
+}
+
+// windowUpdate 把要归还的额度交给sendWindowUpdates发送，读取连接的goroutine不会被写阻塞
+func (c *FrameCoder) windowUpdate(seq uint64, n uint32) {
+	c.mu.Lock()
+	c.updates[seq] += n
+	c.mu.Unlock()
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (c *FrameCoder) sendWindowUpdates() {
+	var payload [windowUpdateLen]byte
+	for {
+		select {
+		case <-c.kick:
+		case <-c.closed:
+			return
+		}
+		c.mu.Lock()
+		updates := c.updates
+		c.updates = make(map[uint64]uint32)
+		c.mu.Unlock()
+		for seq, n := range updates {
+			binary.BigEndian.PutUint64(payload[:], seq)
+			binary.BigEndian.PutUint32(payload[8:], n)
+			if err := c.writeFrame(FrameWindowUpdate, payload[:]); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package coder
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrameCoder(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := NewFrameCoder(c1, NewGobCoder), NewFrameCoder(c2, NewGobCoder)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	go func() {
+		// 未知类型的帧会被跳过
+		_ = client.framer.WriteFrame(FrameType(0xff), []byte("unknown"))
+		// body的类型对不上，只有这个消息解码失败
+		_ = client.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, "not an int")
+		_ = client.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 2}, 42)
+		_ = client.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 3}, 43)
+	}()
+
+	var h Header
+	var n int
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&n); !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expect ErrInvalidFrame, got %v", err)
+	}
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(nil); err != nil {
+		t.Fatal("failed to skip body:", err)
+	}
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 3 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&n); err != nil || n != 43 {
+		t.Fatalf("unexpected body %d, err: %v", n, err)
+	}
+}
+
+// waitQueued 等到c有n个消息在排队
+func waitQueued(t *testing.T, c *FrameCoder, n int) {
+	for i := 0; i < 1000; i++ {
+		c.mu.Lock()
+		queued := 0
+		for _, q := range c.sendq {
+			queued += len(q)
+		}
+		c.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expect %d messages queued", n)
+}
+
+func TestFrameCoder_chunks(t *testing.T) {
+	c1, c2 := net.Pipe()
+	opt := FrameOption{MaxFrameSize: 1 << 10}
+	client, server := NewFrameCoder(c1, NewGobCoder, opt), NewFrameCoder(c2, NewGobCoder, opt)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	// 服务端读取之前两个消息都已经在排队，小消息轮到之后插在大消息的帧之间
+	big := strings.Repeat("x", 256<<10)
+	go func() {
+		_ = client.Write(&Header{ServiceMethod: "Foo.Big", Seq: 1}, big)
+	}()
+	waitQueued(t, client, 1)
+	go func() {
+		_ = client.Write(&Header{ServiceMethod: "Foo.Small", Seq: 2}, "small")
+	}()
+	waitQueued(t, client, 2)
+	// 客户端也要读取连接，才能收到window update
+	go func() {
+		var h Header
+		_ = client.ReadHeader(&h)
+	}()
+
+	var h Header
+	var s string
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("expect the small message first, got %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); err != nil || s != "small" {
+		t.Fatalf("unexpected body %q, err: %v", s, err)
+	}
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&s); err != nil || s != big {
+		t.Fatalf("the big message is broken, err: %v", err)
+	}
+}
+
+func TestFrameCoder_streamWindow(t *testing.T) {
+	c1, c2 := net.Pipe()
+	opt := FrameOption{WindowSize: 1 << 10}
+	client, server := NewFrameCoder(c1, NewGobCoder, opt), NewFrameCoder(c2, NewGobCoder, opt)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	go func() {
+		var h Header
+		_ = client.ReadHeader(&h)
+	}()
+
+	// 比窗口还大的消息也能发送，之后要等接收方解码
+	go func() {
+		_ = client.Write(&Header{Seq: 1, Kind: KindStream}, strings.Repeat("x", 2<<10))
+	}()
+	var h Header
+	var raw RawBody
+	if err := server.ReadHeader(&h); err != nil || server.ReadBody(&raw) != nil {
+		t.Fatal("failed to read stream message:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.WaitWindow(ctx, 1); err != context.DeadlineExceeded {
+		t.Fatalf("expect the stream to wait for its window, got %v", err)
+	}
+	if err := client.WaitWindow(context.Background(), 2); err != nil {
+		t.Fatal("other streams should not wait:", err)
+	}
+	var s string
+	if err := raw.Decode(&s); err != nil || len(s) != 2<<10 {
+		t.Fatal("failed to decode stream message:", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.WaitWindow(ctx, 1); err != nil {
+		t.Fatal("expect the window back after decoding:", err)
+	}
+}
+
+func TestFrameCoder_maxMsgSize(t *testing.T) {
+	c1, c2 := net.Pipe()
+	opt := FrameOption{MaxFrameSize: 1 << 10, MaxRecvMsgSize: 4 << 10, MaxSendMsgSize: 8 << 10}
+	client, server := NewFrameCoder(c1, NewGobCoder, opt), NewFrameCoder(c2, NewGobCoder, opt)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	if err := client.Write(&Header{Seq: 1}, strings.Repeat("x", 16<<10)); !errors.Is(err, ErrMsgTooLarge) {
+		t.Fatalf("expect ErrMsgTooLarge when sending, got %v", err)
+	}
+	go func() {
+		_ = client.Write(&Header{Seq: 2}, strings.Repeat("x", 6<<10))
+		_ = client.Write(&Header{Seq: 3}, "small")
+	}()
+	var h Header
+	if err := server.ReadHeader(&h); !errors.Is(err, ErrMsgTooLarge) || h.Seq != 2 {
+		t.Fatalf("expect ErrMsgTooLarge with seq 2, got %+v, err: %v", h, err)
+	}
+	var s string
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 3 || server.ReadBody(&s) != nil || s != "small" {
+		t.Fatalf("failed to read after a large message, got %+v, err: %v", h, err)
+	}
+}
+
+func (c *FrameCoder) numStreams() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.streams)
+}
+
+func TestFrameCoder_endStream(t *testing.T) {
+	c1, c2 := net.Pipe()
+	opt := FrameOption{WindowSize: 1 << 10}
+	client, server := NewFrameCoder(c1, NewGobCoder, opt), NewFrameCoder(c2, NewGobCoder, opt)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	go func() {
+		var h Header
+		for server.ReadHeader(&h) == nil {
+			// 不解码流上的消息，额度不会归还
+			_ = server.ReadBody(new(RawBody))
+		}
+	}()
+
+	// 取消之后，流没有归还的额度不再记录
+	big := strings.Repeat("x", 2<<10)
+	_ = client.Write(&Header{Seq: 1, Kind: KindStream}, big)
+	if n := client.numStreams(); n != 1 {
+		t.Fatalf("expect the window of stream 1, got %d", n)
+	}
+	_ = client.Write(&Header{Seq: 1, Kind: KindCancel}, struct{}{})
+	if n := client.numStreams(); n != 0 {
+		t.Fatalf("expect the window to be dropped on cancel, got %d", n)
+	}
+
+	// 收到调用的结果之后也一样
+	_ = client.Write(&Header{Seq: 2, Kind: KindStream}, big)
+	go func() {
+		_ = server.Write(&Header{Seq: 2}, struct{}{})
+	}()
+	var h Header
+	if err := client.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if n := client.numStreams(); n != 0 {
+		t.Fatalf("expect the window to be dropped with the response, got %d", n)
+	}
+}
+
+func TestFrameCoder_connWindow(t *testing.T) {
+	opt := FrameOption{MaxFrameSize: 1 << 10, MaxRecvMsgSize: 8 << 10}
+	t.Run("unfinished messages", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		client, server := NewFrameCoder(c1, NewGobCoder, opt), NewFrameCoder(c2, NewGobCoder, opt)
+		defer func() { _ = client.Close() }()
+		defer func() { _ = server.Close() }()
+		go func() {
+			// 不遵守流量控制，开很多个不结束的消息
+			chunk := make([]byte, dataHeaderLen+1<<10)
+			for seq := uint64(1); ; seq++ {
+				binary.BigEndian.PutUint64(chunk, seq)
+				if client.framer.WriteFrame(FrameData, chunk) != nil {
+					return
+				}
+			}
+		}()
+		var h Header
+		if err := server.ReadHeader(&h); !errors.Is(err, ErrFlowControl) {
+			t.Fatalf("expect ErrFlowControl, got %v", err)
+		}
+		if err := server.ReadHeader(&h); err == nil {
+			t.Fatal("expect the connection to be closed")
+		}
+	})
+	t.Run("concurrent messages", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		client, server := NewFrameCoder(c1, NewGobCoder, opt), NewFrameCoder(c2, NewGobCoder, opt)
+		defer func() { _ = client.Close() }()
+		defer func() { _ = server.Close() }()
+		go func() {
+			var h Header
+			_ = client.ReadHeader(&h)
+		}()
+
+		// 窗口只能放下一个消息，开始发送的消息拿到了全部额度，总能发完
+		var wg sync.WaitGroup
+		for i := 1; i <= 20; i++ {
+			wg.Add(1)
+			go func(seq uint64) {
+				defer wg.Done()
+				if err := client.Write(&Header{Seq: seq}, strings.Repeat(fmt.Sprint(seq%10), 7<<10)); err != nil {
+					t.Error("failed to write:", err)
+				}
+			}(uint64(i))
+		}
+		for i := 0; i < 20; i++ {
+			var h Header
+			var s string
+			if err := server.ReadHeader(&h); err != nil || server.ReadBody(&s) != nil || s != strings.Repeat(fmt.Sprint(h.Seq%10), 7<<10) {
+				t.Fatalf("failed to read message %d: %v", i, err)
+			}
+		}
+		wg.Wait()
+	})
+}
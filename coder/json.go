@@ -0,0 +1,68 @@
+package coder
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+type JsonCoder struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+var _ Coder = (*JsonCoder)(nil)
+
+// NewJsonCoder 把conn包装成一个json coder，header和body各是一个json值
+func NewJsonCoder(conn io.ReadWriteCloser) Coder {
+	buf := bufio.NewWriter(conn)
+	return &JsonCoder{
+		conn: conn,
+		buf:  buf,
+		dec:  json.NewDecoder(conn),
+		enc:  json.NewEncoder(buf),
+	}
+}
+
+func (c *JsonCoder) Close() error {
+	return c.conn.Close()
+}
+
+// ReadHeader 读取数据存在Header中
+func (c *JsonCoder) ReadHeader(h *Header) error {
+	return c.dec.Decode(h)
+}
+
+// ReadBody 读取数据存在Body中，body为nil时丢弃这个json值
+func (c *JsonCoder) ReadBody(body interface{}) error {
+	if body == nil {
+		var discard json.RawMessage
+		return c.dec.Decode(&discard)
+	}
+	return c.dec.Decode(body)
+}
+
+// 编码Header和Body，写到buf中，把buf中的数据发送到conn里
+func (c *JsonCoder) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+
+	if err = c.enc.Encode(h); err != nil {
+		log.Println("RPC coder: json encoding header err:", err)
+		return err
+	}
+
+	if err = c.enc.Encode(body); err != nil {
+		log.Println("RPC coder: json encoding body err:", err)
+		return err
+	}
+
+	return nil
+}
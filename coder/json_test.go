@@ -0,0 +1,36 @@
+package coder
+
+import (
+	"net"
+	"testing"
+)
+
+type sumArgs struct{ Num1, Num2 int }
+
+func TestJsonCoder(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := NewJsonCoder(c1), NewJsonCoder(c2)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	go func() {
+		_ = client.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, &sumArgs{1, 2})
+		_ = client.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 2}, &sumArgs{3, 4})
+	}()
+
+	var h Header
+	if err := server.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Sum" || h.Seq != 1 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	// body为nil时跳过，不影响下一个请求
+	if err := server.ReadBody(nil); err != nil {
+		t.Fatal("failed to discard body:", err)
+	}
+	var args sumArgs
+	if err := server.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("unexpected header %+v, err: %v", h, err)
+	}
+	if err := server.ReadBody(&args); err != nil || args.Num1 != 3 || args.Num2 != 4 {
+		t.Fatalf("unexpected body %+v, err: %v", args, err)
+	}
+}
@@ -14,11 +14,12 @@ const debugText = `<html>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Panics</th>
 		{{range $name, $mtype := .Method}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgvType}}, {{$mtype.ReplyType}}) error</td>
+			<td align=left font=fixed>{{$name}}({{if $mtype.HasContext}}context.Context, {{end}}{{with $mtype.ArgvType}}{{.}}, {{end}}{{$mtype.ReplyType}}) error</td>
 			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=center>{{$mtype.NumPanics}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -0,0 +1,44 @@
+package geerpc
+
+import (
+	"errors"
+	"geerpc/coder"
+	"geerpc/status"
+	"runtime"
+)
+
+// setError puts the status of err into the response header h
+func setError(h *coder.Header, err error) {
+	st := status.Convert(err)
+	h.Code = uint32(st.Code)
+	h.Error = st.Message
+	h.Details = st.Details
+}
+
+// headerError rebuilds the status sent by the server, nil if the response isn't an error
+func headerError(h *coder.Header) error {
+	if h.Code == uint32(status.OK) && h.Error == "" {
+		return nil
+	}
+	code := status.Code(h.Code)
+	// 没有状态码的错误
+	if code == status.OK {
+		code = status.Unknown
+	}
+	return &status.Status{Code: code, Message: h.Error, Details: h.Details}
+}
+
+// sendError turns a message too large to send into ResourceExhausted,
+// nothing has been sent and the connection is still usable
+func sendError(err error) error {
+	if errors.Is(err, coder.ErrMsgTooLarge) {
+		return status.Errorf(status.ResourceExhausted, "rpc: %v", err)
+	}
+	return err
+}
+
+// stack returns the stack trace of the calling goroutine, used to log panics
+func stack() []byte {
+	buf := make([]byte, 64<<10)
+	return buf[:runtime.Stack(buf, false)]
+}
@@ -15,7 +15,9 @@ func TestXDial(t *testing.T) {
 			_ = os.Remove(addr)
 			l, err := net.Listen("unix", addr)
 			if err != nil {
-				t.Fatal("failed to listen unix socket")
+				t.Error("failed to listen unix socket")
+				close(ch)
+				return
 			}
 			ch <- struct{}{}
 			Accept(l)
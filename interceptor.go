@@ -0,0 +1,82 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/coder"
+)
+
+// Handler invokes the service method of a request
+type Handler func(ctx context.Context, h *coder.Header, argv, reply interface{}) error
+
+// ServerInterceptor wraps every method invocation on the server.
+// It must call next to go on, or return an error to short-circuit the call.
+type ServerInterceptor func(ctx context.Context, h *coder.Header, argv, reply interface{}, next Handler) error
+
+// chainServerInterceptors 把拦截器串起来，先注册的在最外层
+func chainServerInterceptors(interceptors []ServerInterceptor, handler Handler) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, h *coder.Header, argv, reply interface{}) error {
+			return interceptor(ctx, h, argv, reply, next)
+		}
+	}
+	return handler
+}
+
+// Use appends interceptors to the server, they run in the order they are added
+func (s *Server) Use(interceptors ...ServerInterceptor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+// Use is a convenient approach for default server to add interceptors
+func Use(interceptors ...ServerInterceptor) {
+	DefaultServer.Use(interceptors...)
+}
+
+// handler returns the method invocation of req wrapped by the interceptors
+func (s *Server) handler(req *request) Handler {
+	s.mu.RLock()
+	interceptors := s.interceptors
+	s.mu.RUnlock()
+	return chainServerInterceptors(interceptors, func(ctx context.Context, _ *coder.Header, _, _ interface{}) error {
+		return req.svc.call(ctx, req.mType, req.argv, req.replyv)
+	})
+}
+
+// Invoker sends a request and waits for its reply
+type Invoker func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+
+// ClientInterceptor wraps every Call and Go on the client.
+// It must call invoker to send the request, or return an error to short-circuit the call.
+type ClientInterceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker) error
+
+// chainClientInterceptors 把拦截器串起来，先注册的在最外层
+func chainClientInterceptors(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return invoker
+}
+
+// Use appends interceptors to the client, they run in the order they are added
+func (c *Client) Use(interceptors ...ClientInterceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
+// invoker returns the interceptor chain of the client, nil if there is no interceptor
+func (c *Client) invoker() Invoker {
+	c.mu.Lock()
+	interceptors := c.interceptors
+	c.mu.Unlock()
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return chainClientInterceptors(interceptors, c.invoke)
+}
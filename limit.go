@@ -0,0 +1,180 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/coder"
+	"geerpc/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServerOption limits the requests a Server runs at the same time and the messages of its
+// connections, zero fields mean no limit unless told otherwise.
+// A request holds its slots until its method returns, even after the request timed out.
+type ServerOption struct {
+	MaxConcurrentRequests          int // on the whole server
+	MaxConcurrentRequestsPerConn   int // on each connection
+	MaxConcurrentRequestsPerMethod int // of each method
+	// Workers runs the methods on a pool of this many goroutines instead of a new goroutine
+	// per request. A method still running after its request timed out keeps its worker.
+	Workers int
+	// QueueTimeout is how long a request waits for a slot when a limit is hit,
+	// 0 rejects it at once. A request that can't get a slot fails with ErrOverloaded.
+	QueueTimeout time.Duration
+	// MaxQueuedRequests bounds the requests waiting for a slot on the whole server, a request
+	// that would wait beyond it fails with ErrOverloaded at once. Default 1024.
+	MaxQueuedRequests int
+	// AdaptiveLimit sheds the requests above a concurrency limit adjusted to the latency of
+	// the server with ErrOverloaded, nil disables it. Streaming calls are not limited by it.
+	AdaptiveLimit *AdaptiveLimitOption
+	// limits of the messages and the flow control windows of each connection, 0 means the
+	// default of coder.FrameOption. A connection uses the stricter of these and the Option
+	// sent by the client, whatever the client asks for.
+	WindowSize     uint32
+	ConnWindowSize uint32
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+}
+
+func (opt *ServerOption) frameOption() coder.FrameOption {
+	return coder.FrameOption{
+		WindowSize:     opt.WindowSize,
+		ConnWindowSize: opt.ConnWindowSize,
+		MaxRecvMsgSize: opt.MaxRecvMsgSize,
+		MaxSendMsgSize: opt.MaxSendMsgSize,
+	}
+}
+
+// ErrOverloaded is returned for a request rejected by the limits of ServerOption before
+// its method ran, it can be retried later or on another server
+var ErrOverloaded = status.New(status.Unavailable, "rpc server: overloaded")
+
+// newLimit returns a semaphore of n slots, nil if there is no limit
+func newLimit(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+// admit 依次占用连接、方法、服务端和worker池的名额，有一个占不到就释放已经占用的。
+// release释放除worker之外的名额，worker的名额在方法返回时由worker自己释放
+func (s *Server) admit(ctx context.Context, sems ...chan struct{}) (release func(), err error) {
+	var timeout <-chan time.Time
+	if s.opt.QueueTimeout > 0 {
+		timer := time.NewTimer(s.opt.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var acquired []chan struct{}
+	release = func() {
+		for _, sem := range acquired {
+			<-sem
+		}
+	}
+	if s.pool != nil {
+		sems = append(sems, s.pool.sem)
+	}
+	for i, sem := range sems {
+		if sem == nil {
+			continue
+		}
+		if err = s.acquire(ctx, sem, timeout); err != nil {
+			release()
+			return nil, err
+		}
+		if s.pool == nil || i < len(sems)-1 {
+			acquired = append(acquired, sem)
+		}
+	}
+	return release, nil
+}
+
+// defaultMaxQueuedRequests 默认最多排队的请求数，排队的请求都带着解码好的参数
+const defaultMaxQueuedRequests = 1024
+
+// acquire 占用sem的一个名额，timeout为nil或者排队的请求已满时不等待
+func (s *Server) acquire(ctx context.Context, sem chan struct{}, timeout <-chan time.Time) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	default:
+	}
+	if timeout == nil {
+		return ErrOverloaded
+	}
+	if atomic.AddInt32(&s.queued, 1) > int32(s.opt.MaxQueuedRequests) {
+		atomic.AddInt32(&s.queued, -1)
+		return ErrOverloaded
+	}
+	defer atomic.AddInt32(&s.queued, -1)
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-timeout:
+		return ErrOverloaded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run runs f on a worker of the pool, or on a new goroutine if there is no pool.
+// The worker slot must have been taken by admit.
+func (s *Server) run(f func()) {
+	if s.pool == nil {
+		go f()
+		return
+	}
+	s.pool.run(f)
+}
+
+// workerPool runs functions on a fixed number of goroutines
+type workerPool struct {
+	sem   chan struct{} // a slot for each busy worker
+	tasks chan func()
+	quit  chan struct{}
+	once  sync.Once
+}
+
+func newWorkerPool(n int) *workerPool {
+	p := &workerPool{
+		sem:   make(chan struct{}, n),
+		tasks: make(chan func()),
+		quit:  make(chan struct{}),
+	}
+	for i := 0; i < n; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *workerPool) worker() {
+	for {
+		select {
+		case f := <-p.tasks:
+			f()
+			<-p.sem
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// run 占到名额之后一定有空闲的worker，最多等它回到循环开始
+func (p *workerPool) run(f func()) {
+	select {
+	case p.tasks <- f:
+	case <-p.quit:
+		// 服务端已经关闭，worker都退出了
+		go func() {
+			f()
+			<-p.sem
+		}()
+	}
+}
+
+// stop stops the workers once they finish their current function
+func (p *workerPool) stop() {
+	p.once.Do(func() { close(p.quit) })
+}
@@ -0,0 +1,127 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Gauge records how many calls run at the same time
+type Gauge struct {
+	cur, max int32
+}
+
+// Hold keeps a slot for ms milliseconds
+func (g *Gauge) Hold(ms int, reply *int) error {
+	n := atomic.AddInt32(&g.cur, 1)
+	for {
+		max := atomic.LoadInt32(&g.max)
+		if n <= max || atomic.CompareAndSwapInt32(&g.max, max, n) {
+			break
+		}
+	}
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	atomic.AddInt32(&g.cur, -1)
+	*reply = ms
+	return nil
+}
+
+// holdAll makes n concurrent calls of Gauge.Hold and returns their errors
+func holdAll(client *Client, n, ms int) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var reply int
+			errs[i] = client.Call(context.Background(), "Gauge.Hold", ms, &reply)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func countOverloaded(errs []error) (n int) {
+	for _, err := range errs {
+		if errors.Is(err, ErrOverloaded) {
+			n++
+		} else {
+			_assert(err == nil, "unexpected error: %v", err)
+		}
+	}
+	return n
+}
+
+func TestServer_limits(t *testing.T) {
+	start := func(opt *ServerOption) (*Gauge, *Client) {
+		g := new(Gauge)
+		var foo Foo
+		s := NewServer(opt)
+		_ = s.Register(g)
+		_ = s.Register(&foo)
+		client, _ := Dial("tcp", startTestServer(s))
+		t.Cleanup(func() { _ = client.Close() })
+		return g, client
+	}
+	t.Run("reject", func(t *testing.T) {
+		g, client := start(&ServerOption{MaxConcurrentRequestsPerMethod: 2})
+		ch := make(chan []error)
+		go func() { ch <- holdAll(client, 2, 200) }()
+		time.Sleep(50 * time.Millisecond)
+		var reply int
+		err := client.Call(context.Background(), "Gauge.Hold", 0, &reply)
+		_assert(errors.Is(err, ErrOverloaded), "expect ErrOverloaded, got %v", err)
+		// 其他方法不受这个方法的限制
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "expect other methods to run: %v", err)
+		_assert(countOverloaded(<-ch) == 0 && g.max == 2, "expect 2 calls to run, max %d", g.max)
+	})
+	t.Run("queue", func(t *testing.T) {
+		g, client := start(&ServerOption{MaxConcurrentRequests: 2, QueueTimeout: time.Second})
+		_assert(countOverloaded(holdAll(client, 6, 30)) == 0, "expect queued calls to succeed")
+		_assert(g.max == 2, "expect at most 2 calls at the same time, got %d", g.max)
+	})
+	t.Run("queue timeout", func(t *testing.T) {
+		g, client := start(&ServerOption{MaxConcurrentRequestsPerConn: 1, QueueTimeout: 20 * time.Millisecond})
+		n := countOverloaded(holdAll(client, 3, 200))
+		_assert(n == 2 && g.max == 1, "expect 2 calls to time out in the queue, got %d", n)
+	})
+	t.Run("queue full", func(t *testing.T) {
+		g, client := start(&ServerOption{MaxConcurrentRequests: 1, QueueTimeout: time.Second, MaxQueuedRequests: 2})
+		n := countOverloaded(holdAll(client, 5, 100))
+		_assert(n == 2 && g.max == 1, "expect 2 calls beyond the queue to be rejected, got %d", n)
+	})
+	t.Run("workers", func(t *testing.T) {
+		g, client := start(&ServerOption{Workers: 3, QueueTimeout: time.Second})
+		_assert(countOverloaded(holdAll(client, 10, 20)) == 0, "expect queued calls to succeed")
+		_assert(g.max == 3, "expect the calls to run on 3 workers, got %d", g.max)
+	})
+	t.Run("deadline in queue", func(t *testing.T) {
+		_, client := start(&ServerOption{MaxConcurrentRequests: 1, QueueTimeout: time.Second})
+		go holdAll(client, 1, 200)
+		time.Sleep(50 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var reply int
+		err := client.Call(ctx, "Gauge.Hold", 0, &reply)
+		_assert(errors.Is(err, context.DeadlineExceeded), "expect DeadlineExceeded while queued, got %v", err)
+	})
+	t.Run("timed out", func(t *testing.T) {
+		_, client := start(&ServerOption{MaxConcurrentRequestsPerMethod: 1})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var reply int
+		err := client.Call(ctx, "Gauge.Hold", 200, &reply)
+		_assert(errors.Is(err, context.DeadlineExceeded), "expect DeadlineExceeded, got %v", err)
+		// 超时的方法还在运行，仍然占着名额
+		err = client.Call(context.Background(), "Gauge.Hold", 0, &reply)
+		_assert(errors.Is(err, ErrOverloaded), "expect ErrOverloaded while the method runs, got %v", err)
+		time.Sleep(250 * time.Millisecond)
+		err = client.Call(context.Background(), "Gauge.Hold", 0, &reply)
+		_assert(err == nil, "expect the slot back after the method returned: %v", err)
+	})
+}
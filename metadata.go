@@ -0,0 +1,108 @@
+package geerpc
+
+import (
+	"context"
+	"sync"
+)
+
+// Metadata is the key-value pairs sent along with a request or a response,
+// such as auth tokens, trace ids or tenant ids
+type Metadata map[string]string
+
+// Copy returns a copy of md
+func (md Metadata) Copy() Metadata {
+	if md == nil {
+		return nil
+	}
+	out := make(Metadata, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+type outgoingMetadataKey struct{}
+type incomingMetadataKey struct{}
+type responseMetadataKey struct{}
+type replyMetadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying md, the pairs are sent with every
+// call made with the returned context. Pairs already in ctx are kept unless md overrides them.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	merged := outgoingMetadata(ctx).Copy()
+	if merged == nil {
+		merged = make(Metadata, len(md))
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, outgoingMetadataKey{}, merged)
+}
+
+// outgoingMetadata returns the metadata to send with a call, nil if there is none
+func outgoingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md
+}
+
+// MetadataFromContext returns the request metadata received by the server,
+// it is available to service methods and server interceptors
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(incomingMetadataKey{}).(Metadata)
+	return md
+}
+
+// WithResponseMetadata returns a copy of ctx which collects the response metadata
+// of calls made with it into md, md must not be nil
+func WithResponseMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, replyMetadataKey{}, md)
+}
+
+// saveResponseMetadata copies the response metadata of a call into the Metadata given by WithResponseMetadata
+func saveResponseMetadata(ctx context.Context, md Metadata) {
+	dst, ok := ctx.Value(replyMetadataKey{}).(Metadata)
+	if !ok || dst == nil {
+		return
+	}
+	for k, v := range md {
+		dst[k] = v
+	}
+}
+
+// responseMetadata collects the pairs a request handler wants to send back
+type responseMetadata struct {
+	mu sync.Mutex
+	md Metadata
+}
+
+// SetResponseMetadata adds md to the response of the request handled with ctx.
+// It returns false if ctx doesn't belong to a request.
+func SetResponseMetadata(ctx context.Context, md Metadata) bool {
+	resp, ok := ctx.Value(responseMetadataKey{}).(*responseMetadata)
+	if !ok {
+		return false
+	}
+	resp.mu.Lock()
+	defer resp.mu.Unlock()
+	if resp.md == nil {
+		resp.md = make(Metadata, len(md))
+	}
+	for k, v := range md {
+		resp.md[k] = v
+	}
+	return true
+}
+
+// newRequestContext 把请求的metadata放进ctx，并准备好收集响应的metadata
+func newRequestContext(ctx context.Context, md Metadata) (context.Context, *responseMetadata) {
+	resp := new(responseMetadata)
+	ctx = context.WithValue(ctx, incomingMetadataKey{}, md)
+	return context.WithValue(ctx, responseMetadataKey{}, resp), resp
+}
+
+// collect returns the pairs set by SetResponseMetadata
+func (r *responseMetadata) collect() Metadata {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.md.Copy()
+}
@@ -0,0 +1,59 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// Peer is the client at the other end of the connection a request arrived on
+type Peer struct {
+	Addr net.Addr
+	TLS  *tls.ConnectionState // nil if the connection isn't TLS
+}
+
+// Certificate returns the verified certificate of a TLS client, nil if the
+// client didn't send one or the server was not configured to verify it
+func (p *Peer) Certificate() *x509.Certificate {
+	if p.TLS == nil || len(p.TLS.VerifiedChains) == 0 || len(p.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return p.TLS.VerifiedChains[0][0]
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the peer of the request, it is available to service
+// methods and server interceptors
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+// newPeer 对TLS连接先完成握手，才能拿到客户端的证书
+func newPeer(conn net.Conn) (*Peer, error) {
+	p := &Peer{Addr: conn.RemoteAddr()}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		state := tlsConn.ConnectionState()
+		p.TLS = &state
+	}
+	return p, nil
+}
+
+// clientTLSConfig 没有设置ServerName时用要连接的主机名校验服务端的证书
+func clientTLSConfig(config *tls.Config, address string) *tls.Config {
+	if config.ServerName != "" || config.InsecureSkipVerify {
+		return config
+	}
+	config = config.Clone()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		config.ServerName = host
+	} else {
+		config.ServerName = address
+	}
+	return config
+}
@@ -0,0 +1,203 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/coder"
+	"geerpc/status"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is the rate of a token bucket, Rate tokens are added per second up to Burst.
+// Burst 0 means Rate rounded up, at least 1. The zero Limit means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitOption configures RateLimit, every call must get a token from each
+// bucket it belongs to
+type RateLimitOption struct {
+	PerAddr      Limit            // each remote host, whatever its port
+	PerPrincipal Limit            // each principal of PrincipalFromContext, see Auth
+	PerMethod    Limit            // each Service.Method
+	Methods      map[string]Limit // overrides PerMethod for the listed Service.Method
+}
+
+// ErrRateLimited is matched by errors.Is for a call rejected by RateLimit, the error
+// carries a hint of when to retry, see RetryAfter
+var ErrRateLimited = status.New(status.ResourceExhausted, "rpc server: rate limited")
+
+const retryAfterDetail = "retry-after="
+
+// RetryAfter returns how long to wait before retrying a call rejected by RateLimit
+func RetryAfter(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	for _, d := range st.Details {
+		if v, ok := strings.CutPrefix(d, retryAfterDetail); ok {
+			if after, err := time.ParseDuration(v); err == nil {
+				return after, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// RateLimit returns an interceptor rejecting the calls above the limits of opt with
+// ErrRateLimited. Add it after Auth to limit each principal.
+func RateLimit(opt *RateLimitOption) ServerInterceptor {
+	addrs := newBuckets(opt.PerAddr)
+	principals := newBuckets(opt.PerPrincipal)
+	methods := make(map[string]*buckets, len(opt.Methods))
+	for m, l := range opt.Methods {
+		methods[m] = newBuckets(l)
+	}
+	perMethod := newBuckets(opt.PerMethod)
+	return func(ctx context.Context, h *coder.Header, argv, reply interface{}, next Handler) error {
+		var addr, principal string
+		if p, ok := PeerFromContext(ctx); ok && p.Addr != nil {
+			addr = p.Addr.String()
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				addr = host
+			}
+		}
+		principal, hasPrincipal := PrincipalFromContext(ctx)
+		method := perMethod
+		if m, ok := methods[h.ServiceMethod]; ok {
+			method = m
+		}
+
+		now := time.Now()
+		// 后面的桶拒绝时，把前面拿到的令牌还回去
+		var taken []*bucket
+		take := func(bs *buckets, key string) time.Duration {
+			b := bs.get(key, now)
+			if b == nil {
+				return 0
+			}
+			if wait := b.take(now); wait > 0 {
+				return wait
+			}
+			taken = append(taken, b)
+			return 0
+		}
+		wait := take(addrs, addr)
+		if wait == 0 && hasPrincipal {
+			wait = take(principals, principal)
+		}
+		if wait == 0 {
+			wait = take(method, h.ServiceMethod)
+		}
+		if wait > 0 {
+			for _, b := range taken {
+				b.refund()
+			}
+			return ErrRateLimited.WithDetails(retryAfterDetail + wait.String())
+		}
+		return next(ctx, h, argv, reply)
+	}
+}
+
+// bucket is a token bucket
+type bucket struct {
+	mu     sync.Mutex
+	limit  Limit
+	tokens float64
+	last   time.Time // tokens were last added at
+}
+
+// fill 按照经过的时间补充令牌，不超过Burst
+func (b *bucket) fill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+		b.last = now
+	}
+	if burst := float64(b.limit.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+}
+
+// take takes a token, or returns how long until there is one
+func (b *bucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	if b.limit.Rate <= 0 {
+		return time.Hour
+	}
+	// 至少1ns，0表示拿到了令牌
+	wait := time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+	if wait <= 0 {
+		wait = 1
+	}
+	return wait
+}
+
+func (b *bucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// full reports whether the bucket is back to Burst, it is then the same as a new one
+func (b *bucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill(now)
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+// buckets are the token buckets of a Limit, one per key
+type buckets struct {
+	limit     Limit
+	mu        sync.Mutex // protect following
+	m         map[string]*bucket
+	lastSweep time.Time
+}
+
+func newBuckets(l Limit) *buckets {
+	if l == (Limit{}) {
+		return nil
+	}
+	// 桶装不下一个令牌时所有调用都会被拒绝
+	if l.Burst <= 0 {
+		l.Burst = int(math.Max(1, math.Ceil(l.Rate)))
+	}
+	return &buckets{limit: l, m: make(map[string]*bucket)}
+}
+
+// sweepInterval 每隔一段时间删除已经装满的桶，不让没有流量的key一直占着内存
+const sweepInterval = time.Minute
+
+// get returns the bucket of key, nil if bs has no limit
+func (bs *buckets) get(key string, now time.Time) *bucket {
+	if bs == nil {
+		return nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if now.Sub(bs.lastSweep) > sweepInterval {
+		for k, b := range bs.m {
+			if b.full(now) {
+				delete(bs.m, k)
+			}
+		}
+		bs.lastSweep = now
+	}
+	b := bs.m[key]
+	if b == nil {
+		b = &bucket{limit: bs.limit, tokens: float64(bs.limit.Burst), last: now}
+		bs.m[key] = b
+	}
+	return b
+}
@@ -0,0 +1,87 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"geerpc/status"
+	"testing"
+	"time"
+)
+
+func TestServer_RateLimit(t *testing.T) {
+	var foo Foo
+	var account Account
+	s := NewServer()
+	_ = s.Register(&foo)
+	_ = s.Register(&account)
+	s.Use(Auth(BearerTokenAuthenticator(func(token string) (string, error) {
+		return token, nil
+	}), nil))
+	s.Use(RateLimit(&RateLimitOption{
+		PerPrincipal: Limit{Rate: 10, Burst: 3},
+		Methods:      map[string]Limit{"Account.Whoami": {Rate: 10, Burst: 1}},
+	}))
+	addr := startTestServer(s)
+	dial := func(token string) *Client {
+		client, _ := Dial("tcp", addr, &Option{Credentials: BearerToken(token)})
+		t.Cleanup(func() { _ = client.Close() })
+		return client
+	}
+	sum := func(client *Client) error {
+		var reply int
+		return client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	}
+
+	alice, bob := dial("alice"), dial("bob")
+	for i := 0; i < 3; i++ {
+		_assert(sum(alice) == nil, "expect the burst to be allowed")
+	}
+	err := sum(alice)
+	_assert(errors.Is(err, ErrRateLimited) && errors.Is(err, status.ResourceExhausted), "expect ErrRateLimited, got %v", err)
+	after, ok := RetryAfter(err)
+	_assert(ok && after > 0 && after <= 100*time.Millisecond, "expect a retry-after hint, got %v", after)
+	// 每个principal有自己的桶
+	_assert(sum(bob) == nil, "expect bob not to be limited by alice")
+	time.Sleep(after)
+	_assert(sum(alice) == nil, "expect a call to be allowed after retry-after")
+
+	// 方法的限制对所有调用方生效，被拒绝时principal的令牌会还回去
+	var name string
+	carol, dave := dial("carol"), dial("dave")
+	_assert(carol.Call(context.Background(), "Account.Whoami", 0, &name) == nil, "failed to call")
+	err = dave.Call(context.Background(), "Account.Whoami", 0, &name)
+	_assert(errors.Is(err, ErrRateLimited), "expect the method to be limited, got %v", err)
+	for i := 0; i < 3; i++ {
+		_assert(sum(dave) == nil, "expect the token of dave to be refunded")
+	}
+}
+
+func TestRateLimit_perAddr(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	_ = s.Register(&foo)
+	s.Use(RateLimit(&RateLimitOption{PerAddr: Limit{Rate: 1, Burst: 2}}))
+	addr := startTestServer(s)
+
+	// 同一个主机的两个连接共用一个桶
+	var reply int
+	c1, _ := Dial("tcp", addr)
+	c2, _ := Dial("tcp", addr)
+	defer func() { _ = c1.Close() }()
+	defer func() { _ = c2.Close() }()
+	_assert(c1.Call(context.Background(), "Foo.Sum", Args{}, &reply) == nil, "failed to call")
+	_assert(c2.Call(context.Background(), "Foo.Sum", Args{}, &reply) == nil, "failed to call")
+	err := c1.Call(context.Background(), "Foo.Sum", Args{}, &reply)
+	_assert(errors.Is(err, ErrRateLimited), "expect the host to be limited, got %v", err)
+}
+
+func TestRateLimit_defaultBurst(t *testing.T) {
+	now := time.Now()
+	b := newBuckets(Limit{Rate: 2.5}).get("", now)
+	for i := 0; i < 3; i++ {
+		_assert(b.take(now) == 0, "expect a burst of 3, failed at %d", i)
+	}
+	_assert(b.take(now) > 0, "expect the fourth token to wait")
+	b = newBuckets(Limit{Rate: 0.5}).get("", now)
+	_assert(b.take(now) == 0 && b.take(now) > 0, "expect a burst of 1")
+}
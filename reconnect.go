@@ -0,0 +1,190 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/coder"
+	"geerpc/status"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// PendingPolicy decides what happens to the calls made while a client is reconnecting
+type PendingPolicy int
+
+const (
+	FailPending  PendingPolicy = iota // fail at once with ErrShutdown, or ErrGoAway while the server drains
+	QueuePending                      // wait for the new connection until the ctx of the call ends
+)
+
+// ReconnectOption makes a client dialed by Dial, DialHTTP or XDial connect again to the same
+// address when the connection breaks, redoing the handshake. The attempts are spaced by an
+// exponential backoff with jitter, zero fields take the defaults.
+// The calls already sent when the connection breaks always fail, the server may have run them.
+type ReconnectOption struct {
+	InitialBackoff time.Duration // default 100ms
+	MaxBackoff     time.Duration // default 10s
+	Multiplier     float64       // default 1.6
+	Jitter         float64       // each backoff is randomized by ±Jitter of it, default 0.2
+	MaxAttempts    int           // the client shuts down after this many failed attempts, 0 means no limit
+	Pending        PendingPolicy
+}
+
+func (opt *ReconnectOption) withDefaults() ReconnectOption {
+	o := *opt
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 10 * time.Second
+	}
+	if o.MaxBackoff < o.InitialBackoff {
+		o.MaxBackoff = o.InitialBackoff
+	}
+	if o.Multiplier < 1 {
+		o.Multiplier = 1.6
+	}
+	if o.Jitter <= 0 || o.Jitter > 1 {
+		o.Jitter = 0.2
+	}
+	return o
+}
+
+// jitter 在backoff上下浮动一定比例，避免客户端同时重连
+func jitter(backoff time.Duration, ratio float64) time.Duration {
+	return time.Duration(float64(backoff) * (1 + ratio*(2*rand.Float64()-1)))
+}
+
+// handshakeFunc 在新的连接上完成握手，返回收发消息的coder
+type handshakeFunc func(conn net.Conn, opt *Option) (coder.Coder, error)
+
+// withReconnect 设置了Option.Reconnect时，让client断开后用同样的方式连接同一个地址
+func withReconnect(client *Client, err error, handshake handshakeFunc, network, address string) (*Client, error) {
+	if err != nil || client.opt.Reconnect == nil {
+		return client, err
+	}
+	opt := client.opt
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.redial = func() (coder.Coder, error) {
+		// 借用dialTimeout的连接超时和TLS握手，只取出握手后的coder，响应仍由原来的client接收
+		c, err := dialTimeout(func(conn net.Conn, opt *Option) (*Client, error) {
+			cc, err := handshake(conn, opt)
+			if err != nil {
+				return nil, err
+			}
+			return &Client{cc: cc}, nil
+		}, network, address, opt)
+		if err != nil {
+			return nil, err
+		}
+		return c.cc, nil
+	}
+	return client, nil
+}
+
+// startReconnect returns the channel closed once the client is connected again,
+// nil if the client doesn't reconnect
+func (c *Client) startReconnect() chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconnected
+}
+
+// reconnect dials until it succeeds, the client is closed or it runs out of attempts
+func (c *Client) reconnect(reconnected chan struct{}) {
+	opt := c.opt.Reconnect.withDefaults()
+	backoff := opt.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(jitter(backoff, opt.Jitter))
+		select {
+		case <-timer.C:
+		case <-reconnected:
+			// Close关闭了channel
+			timer.Stop()
+			return
+		}
+		cc, err := c.redial()
+		if err == nil {
+			if !c.resume(cc) {
+				_ = cc.Close()
+			}
+			return
+		}
+		log.Println("rpc client: reconnect error:", err)
+		if opt.MaxAttempts > 0 && attempt >= opt.MaxAttempts {
+			c.giveUp()
+			return
+		}
+		if backoff = time.Duration(float64(backoff) * opt.Multiplier); backoff > opt.MaxBackoff {
+			backoff = opt.MaxBackoff
+		}
+	}
+}
+
+// resume switches the client to cc and wakes up the queued calls,
+// it returns false if the client has been closed meanwhile
+func (c *Client) resume(cc coder.Coder) bool {
+	// 拿到sending锁之后没有请求正在发送，可以替换coder
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return false
+	}
+	c.cc = cc
+	c.shutdown = false
+	c.draining = false
+	close(c.reconnected)
+	c.reconnected = nil
+	go c.receive(cc)
+	return true
+}
+
+// giveUp shuts the client down for good, the queued calls fail with ErrShutdown
+func (c *Client) giveUp() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reconnected != nil {
+		close(c.reconnected)
+		c.reconnected = nil
+	}
+}
+
+// queueing reports whether the new calls wait for the client to reconnect
+func (c *Client) queueing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconnected != nil && c.opt.Reconnect.Pending == QueuePending
+}
+
+// awaitConnected waits until the client is connected again if it queues the calls,
+// otherwise registerCall fails the call
+func (c *Client) awaitConnected(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for {
+		c.mu.Lock()
+		reconnected := c.reconnected
+		queue := reconnected != nil && c.opt.Reconnect.Pending == QueuePending
+		c.mu.Unlock()
+		if !queue {
+			return nil
+		}
+		select {
+		case <-reconnected:
+		case <-ctx.Done():
+			return status.Errorf(status.CodeOf(ctx.Err()), "rpc client: call failed: %v", ctx.Err())
+		}
+	}
+}
+
+// coder returns the coder of the current connection
+func (c *Client) coder() coder.Coder {
+	c.sending.RLock()
+	defer c.sending.RUnlock()
+	return c.cc
+}
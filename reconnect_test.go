@@ -0,0 +1,146 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// killableServer 记录接受的连接，kill一起断开它们，监听仍然继续
+type killableServer struct {
+	l     net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startKillableServer(t *testing.T) *killableServer {
+	s := NewServer()
+	var foo Foo
+	_ = s.Register(&foo)
+	_ = s.Register(new(Gauge))
+	l, _ := net.Listen("tcp", ":0")
+	ks := &killableServer{l: l}
+	t.Cleanup(func() {
+		_ = l.Close()
+		ks.kill()
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			ks.mu.Lock()
+			ks.conns = append(ks.conns, conn)
+			ks.mu.Unlock()
+			go s.ServeConn(conn)
+		}
+	}()
+	return ks
+}
+
+func (ks *killableServer) kill() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for _, conn := range ks.conns {
+		_ = conn.Close()
+	}
+	ks.conns = nil
+}
+
+func sum(ctx context.Context, client *Client) error {
+	var reply int
+	err := client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	if err == nil && reply != 3 {
+		return errors.New("wrong reply")
+	}
+	return err
+}
+
+func TestClient_reconnect(t *testing.T) {
+	dial := func(t *testing.T, addr string, opt *ReconnectOption) *Client {
+		client, err := Dial("tcp", addr, &Option{Reconnect: opt})
+		_assert(err == nil, "failed to dial: %v", err)
+		t.Cleanup(func() { _ = client.Close() })
+		return client
+	}
+	t.Run("queue", func(t *testing.T) {
+		ks := startKillableServer(t)
+		client := dial(t, ks.l.Addr().String(), &ReconnectOption{InitialBackoff: 10 * time.Millisecond, Pending: QueuePending})
+		_assert(sum(context.Background(), client) == nil, "expect the call to succeed")
+
+		// 已经发送的call随连接一起失败
+		ch := make(chan error)
+		go func() {
+			var reply int
+			ch <- client.Call(context.Background(), "Gauge.Hold", 200, &reply)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		ks.kill()
+		_assert(<-ch != nil, "expect the call in flight to fail")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_assert(sum(ctx, client) == nil, "expect the call to wait for the new connection")
+		_assert(client.IsAvailable(), "expect the client to be available again")
+	})
+	t.Run("fail", func(t *testing.T) {
+		ks := startKillableServer(t)
+		client := dial(t, ks.l.Addr().String(), &ReconnectOption{InitialBackoff: 50 * time.Millisecond})
+		ks.kill()
+		time.Sleep(10 * time.Millisecond)
+		err := sum(context.Background(), client)
+		_assert(errors.Is(err, ErrShutdown), "expect ErrShutdown while reconnecting, got %v", err)
+		for i := 0; i < 100 && !client.IsAvailable(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_assert(sum(context.Background(), client) == nil, "expect the call to succeed after reconnecting")
+	})
+	t.Run("give up", func(t *testing.T) {
+		ks := startKillableServer(t)
+		client := dial(t, ks.l.Addr().String(), &ReconnectOption{InitialBackoff: 10 * time.Millisecond, MaxAttempts: 2, Pending: QueuePending})
+		_ = ks.l.Close()
+		ks.kill()
+		time.Sleep(10 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		err := sum(ctx, client)
+		_assert(errors.Is(err, ErrShutdown), "expect ErrShutdown after the last attempt, got %v", err)
+		_assert(!client.IsAvailable(), "expect the client to stay shut down")
+	})
+	t.Run("go away", func(t *testing.T) {
+		var foo Foo
+		s := NewServer()
+		_ = s.Register(&foo)
+		addr := startTestServer(s)
+		client := dial(t, addr, &ReconnectOption{InitialBackoff: 10 * time.Millisecond, Pending: QueuePending})
+		_assert(sum(context.Background(), client) == nil, "expect the call to succeed")
+		_assert(s.Shutdown(context.Background()) == nil, "failed to shut down")
+
+		// 同一个地址上启动新的服务端
+		s = NewServer()
+		_ = s.Register(&foo)
+		l, err := net.Listen("tcp", addr)
+		_assert(err == nil, "failed to listen: %v", err)
+		go s.Accept(l)
+		defer func() { _ = s.Shutdown(context.Background()) }()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_assert(sum(ctx, client) == nil, "expect the call to go to the new server")
+	})
+	t.Run("close", func(t *testing.T) {
+		ks := startKillableServer(t)
+		client := dial(t, ks.l.Addr().String(), &ReconnectOption{InitialBackoff: time.Hour, Pending: QueuePending})
+		ks.kill()
+		time.Sleep(10 * time.Millisecond)
+		ch := make(chan error)
+		go func() { ch <- sum(context.Background(), client) }()
+		time.Sleep(50 * time.Millisecond)
+		_ = client.Close()
+		err := <-ch
+		_assert(errors.Is(err, ErrShutdown), "expect the queued call to fail on Close, got %v", err)
+	})
+}
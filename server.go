@@ -1,9 +1,13 @@
 package geerpc
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"geerpc/coder"
+	"geerpc/status"
 	"io"
 	"log"
 	"net"
@@ -22,6 +26,28 @@ type Option struct {
 	CoderType      coder.Type
 	ConnectTimeout time.Duration // 0 means no limit
 	HandleTimeout  time.Duration
+	// flow control windows used by both ends of the connection, 0 means the default.
+	// The server may apply stricter limits, see ServerOption.
+	// WindowSize bounds the undecoded messages of a stream, ConnWindowSize the unfinished messages of the connection.
+	WindowSize     uint32
+	ConnWindowSize uint32
+	// encoded size limits of the messages received and sent by both ends, 0 means the default
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+
+	Interceptors []ClientInterceptor `json:"-"` // client side only, applied to Call and Go
+	TLSConfig    *tls.Config         `json:"-"` // client side only, dial with TLS if set
+	Credentials  Credentials         `json:"-"` // client side only, sent with every call
+	Reconnect    *ReconnectOption    `json:"-"` // client side only, connect again when the connection breaks
+}
+
+func (opt *Option) frameOption() coder.FrameOption {
+	return coder.FrameOption{
+		WindowSize:     opt.WindowSize,
+		ConnWindowSize: opt.ConnWindowSize,
+		MaxRecvMsgSize: opt.MaxRecvMsgSize,
+		MaxSendMsgSize: opt.MaxSendMsgSize,
+	}
 }
 
 var DefaultOption = &Option{
@@ -32,12 +58,34 @@ var DefaultOption = &Option{
 
 // Server RPC Server
 type Server struct {
-	serviceMap sync.Map // map[string]*service
+	opt          ServerOption
+	sem          chan struct{}    // slots of MaxConcurrentRequests
+	pool         *workerPool      // nil if Workers is 0
+	adaptive     *adaptiveLimiter // nil if AdaptiveLimit is nil
+	queued       int32            // requests waiting for a slot in admit
+	serviceMap   sync.Map         // map[string]*service
+	mu           sync.RWMutex     // protect following
+	interceptors []ServerInterceptor
+	listeners    map[net.Listener]struct{}
+	conns        map[*serverConn]struct{}
+	inShutdown   bool
 }
 
-// NewServer returns a new RPC Server
-func NewServer() *Server {
-	return &Server{}
+// NewServer returns a new Server, opts sets the limits on the requests it runs at the same time
+func NewServer(opts ...*ServerOption) *Server {
+	s := &Server{}
+	if len(opts) > 0 && opts[0] != nil {
+		s.opt = *opts[0]
+	}
+	if s.opt.MaxQueuedRequests <= 0 {
+		s.opt.MaxQueuedRequests = defaultMaxQueuedRequests
+	}
+	s.sem = newLimit(s.opt.MaxConcurrentRequests)
+	if s.opt.Workers > 0 {
+		s.pool = newWorkerPool(s.opt.Workers)
+	}
+	s.adaptive = newAdaptiveLimiter(s.opt.AdaptiveLimit)
+	return s
 }
 
 // DefaultServer default RPC server instance
@@ -46,6 +94,9 @@ var DefaultServer = NewServer()
 // Register 服务器注册服务 receiver是一个结构体指针
 func (s *Server) Register(receiver interface{}) error {
 	svc := NewService(receiver)
+	for _, m := range svc.method {
+		m.sem = newLimit(s.opt.MaxConcurrentRequestsPerMethod)
+	}
 	if _, exist := s.serviceMap.LoadOrStore(svc.name, svc); exist {
 		return errors.New("rpc: service already defined: " + svc.name)
 	}
@@ -62,87 +113,251 @@ func (s *Server) findService(serviceMethod string) (svc *service, mtype *methodT
 	dot := strings.LastIndex(serviceMethod, ".")
 	// 没有找到.，返回错误
 	if dot < 0 {
-		err = errors.New("rpc: service/method request ill-formed: " + serviceMethod)
+		err = status.New(status.InvalidArgument, "rpc: service/method request ill-formed: "+serviceMethod)
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	serviceInstance, ok := s.serviceMap.Load(serviceName)
 	if !ok {
-		err = errors.New("rpc: can't find service " + serviceName)
+		err = status.New(status.NotFound, "rpc: can't find service "+serviceName)
 		return
 	}
 	// 转换成service类型
 	svc = serviceInstance.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc: can't find method " + methodName)
+		err = status.New(status.NotFound, "rpc: can't find method "+methodName)
 	}
 	return
 }
 
 // Accept accepts connections of the listener and serve it
 func (s *Server) Accept(listener net.Listener) {
+	if !s.trackListener(listener, true) {
+		_ = listener.Close()
+		return
+	}
+	defer s.trackListener(listener, false)
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Println("RPC server: accept err:", err)
+			// Shutdown关闭了listener，不是错误
+			if !s.shuttingDown() {
+				log.Println("RPC server: accept err:", err)
+			}
 			return
 		}
 		go s.ServeConn(conn)
 	}
 }
 
+// AcceptTLS accepts TLS connections on the listener and serves them. Set
+// config.ClientAuth to tls.RequireAndVerifyClientCert for mutual TLS, the
+// verified client certificate is then available through PeerFromContext.
+func (s *Server) AcceptTLS(listener net.Listener, config *tls.Config) {
+	s.Accept(tls.NewListener(listener, config))
+}
+
+// AcceptTLS accepts TLS connections of the listener and serve it
+func AcceptTLS(listener net.Listener, config *tls.Config) {
+	DefaultServer.AcceptTLS(listener, config)
+}
+
+// handshake is the reply to Option, it tells the client which coder
+// the server accepted, or why the connection is refused
+type handshake struct {
+	CoderType coder.Type
+	Error     string
+	// limits the server applied to the connection, the client must use them too
+	WindowSize     uint32 `json:",omitempty"`
+	ConnWindowSize uint32 `json:",omitempty"`
+	MaxRecvMsgSize int    `json:",omitempty"`
+	MaxSendMsgSize int    `json:",omitempty"`
+}
+
+func (hs *handshake) frameOption() coder.FrameOption {
+	return coder.FrameOption{
+		WindowSize:     hs.WindowSize,
+		ConnWindowSize: hs.ConnWindowSize,
+		MaxRecvMsgSize: hs.MaxRecvMsgSize,
+		MaxSendMsgSize: hs.MaxSendMsgSize,
+	}
+}
+
+// byteReader reads one byte at a time, so that decoding the json Option
+// and handshake never consumes the bytes which belong to the coder
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
+
+// writeJSON sends v without a trailing newline, the peer's coder starts right after it
+func writeJSON(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
 // ServeConn serve the connection
 func (s *Server) ServeConn(conn net.Conn) {
 	defer func() { _ = conn.Close() }()
+	p, err := newPeer(conn)
+	if err != nil {
+		log.Println("RPC server: tls handshake err:", err)
+		return
+	}
 	var opt Option
 	// 解码conn里面json的Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+	if err := json.NewDecoder(byteReader{conn}).Decode(&opt); err != nil {
 		log.Println("RPC server: decode options err:", err)
 		return
 	}
 
 	if opt.MagicNumber != MagicNUmber {
 		log.Println("RPC server: invalid magic number:", opt.MagicNumber)
+		_ = writeJSON(conn, &handshake{Error: fmt.Sprintf("rpc server: invalid magic number %x", opt.MagicNumber)})
 		return
 	}
 
 	// the NewGobCoder
-	coderFunc, ok := coder.NewCoderFuncMap[opt.CoderType]
+	coderFunc, ok := coder.Get(opt.CoderType)
 	if !ok {
 		log.Println("RPC server: invalid code type:", opt.CoderType)
+		_ = writeJSON(conn, &handshake{Error: fmt.Sprintf("rpc server: invalid coder type %s, supported: %v", opt.CoderType, coder.Types())})
+		return
+	}
+	// 限制由服务端决定，客户端只能要求更严格的
+	frameOpt := opt.frameOption().Stricter(s.opt.frameOption())
+	sc := newServerConn(coder.NewFrameCoder(conn, coderFunc, frameOpt), p, s.opt.MaxConcurrentRequestsPerConn)
+	// 先登记连接再回复握手，Shutdown一定能通知到握手成功的连接；
+	// 拿着sending锁，go away消息不会跑到握手回复前面
+	sc.sending.Lock()
+	if !s.trackConn(sc, true) {
+		_ = writeJSON(conn, &handshake{Error: "rpc server: server is shutting down"})
+		sc.sending.Unlock()
+		_ = sc.cc.Close()
+		return
+	}
+	defer s.trackConn(sc, false)
+	// 告诉客户端接受了哪个coder和连接的限制，之后才能开始发送请求
+	err = writeJSON(conn, &handshake{
+		CoderType:      opt.CoderType,
+		WindowSize:     frameOpt.WindowSize,
+		ConnWindowSize: frameOpt.ConnWindowSize,
+		MaxRecvMsgSize: frameOpt.MaxRecvMsgSize,
+		MaxSendMsgSize: frameOpt.MaxSendMsgSize,
+	})
+	sc.sending.Unlock()
+	if err != nil {
+		log.Println("RPC server: send handshake err:", err)
+		sc.close()
+		close(sc.done)
 		return
 	}
-	s.serveCoder(coderFunc(conn), &opt)
+	s.serveConn(sc, &opt)
 }
 
 // invalidRequest the placeholder in response when err occurred
 var invalidRequest = struct{}{}
 
-// serveCoder serve the coder
-func (s *Server) serveCoder(cc coder.Coder, opt *Option) {
-	sending := new(sync.Mutex) // make sure to send a complete response
+// serveConn serve the requests on the connection
+func (s *Server) serveConn(sc *serverConn, opt *Option) {
+	defer close(sc.done)
+	cc := sc.cc
 	wg := new(sync.WaitGroup)
+	inflight := newInflightRequests()
+	drained := false
+	// 读取连接的goroutine不能写：写可能在等连接的额度，而额度要靠它读到的window update归还。
+	// 排空时等待的wg不包括这些错误响应，避免和排空的Wait同时Add
+	var replies sync.WaitGroup
+	replyError := func(h *coder.Header, err error) {
+		setError(h, err)
+		h.Metadata = nil
+		replies.Add(1)
+		go func() {
+			defer replies.Done()
+			s.sendResponse(cc, h, invalidRequest, &sc.sending)
+		}()
+	}
 	for {
 		// 读取数据到request
 		req, err := s.readRequest(cc)
 		if err != nil {
 			// header读取失败，直接返回
 			if req == nil {
+				// 损坏的帧不知道是哪个请求，跳过它继续读下一个
+				if errors.Is(err, coder.ErrInvalidFrame) {
+					continue
+				}
 				break
 			}
+			// 太大的消息可能属于一个正在进行的流，流也随之结束
+			if errors.Is(err, coder.ErrMsgTooLarge) {
+				inflight.cancel(req.header.Seq)
+				err = status.Errorf(status.ResourceExhausted, "rpc server: %v", err)
+			}
 			// body读取失败，发送错误
-			req.header.Error = err.Error()
-			// 返回响应必须是逐个发送的，用mutex来约束
-			s.sendResponse(cc, req.header, invalidRequest, sending)
+			replyError(req.header, err)
 			continue
 		}
-		wg.Add(1)
-		go s.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		switch req.header.Kind {
+		case coder.KindCancel:
+			inflight.cancel(req.header.Seq)
+		case coder.KindStream:
+			if st := inflight.stream(req.header.Seq); st != nil {
+				st.push(req.raw)
+			} else {
+				req.raw.Discard()
+			}
+		case coder.KindCloseSend:
+			if st := inflight.stream(req.header.Seq); st != nil {
+				st.closeRecv()
+			}
+		case coder.KindGoAway:
+			// 客户端确认不会再发送新的请求，处理完已有的请求就可以关闭连接。
+			// 继续读取连接，还没结束的流仍然会收到客户端发来的消息
+			if !drained {
+				drained = true
+				go func() {
+					wg.Wait()
+					sc.close()
+				}()
+			}
+		default:
+			if drained {
+				replyError(req.header, status.New(status.Unavailable, "rpc server: connection is going away"))
+				continue
+			}
+			if req.mType.streaming {
+				st := newServerStream(cc, req.header, &sc.sending)
+				req.replyv = reflect.ValueOf(st)
+				// 在读取下一个消息之前注册，客户端紧接着发送的消息才能找到这个流
+				if req.mType.recvStream {
+					inflight.addStream(req.header.Seq, st)
+				}
+			}
+			// 同样在读取下一个消息之前注册，紧接着到达的取消才能找到这个请求
+			ctx, done := inflight.add(sc.ctx, req.header.Seq)
+			wg.Add(1)
+			go s.handleRequest(ctx, done, sc, req, wg, opt.HandleTimeout)
+		}
+	}
+	// 连接断开时取消所有请求的ctx，正常排空时等待请求处理完
+	if !drained {
+		sc.cancel()
 	}
 	wg.Wait()
-	_ = cc.Close()
-
+	replies.Wait()
+	sc.close()
 }
 
 // Accept accepts connections of the listener and serve it
@@ -151,10 +366,11 @@ func Accept(listener net.Listener) {
 }
 
 type request struct {
-	header *coder.Header // header of request
-	argv   reflect.Value // argv of request
-	replyv reflect.Value // replyv of request
-	mType  *methodType   // methodType of request
+	header *coder.Header  // header of request
+	argv   reflect.Value  // argv of request
+	replyv reflect.Value  // replyv of request, or the *ServerStream of a streaming method
+	raw    *coder.RawBody // body of a KindStream message
+	mType  *methodType    // methodType of request
 	svc    *service
 }
 
@@ -162,6 +378,11 @@ func (s *Server) readRequestHeader(cc coder.Coder) (*coder.Header, error) {
 	var header coder.Header
 	// 从conn里面读取数据，存到header里面
 	if err := cc.ReadHeader(&header); err != nil {
+		// 太大的消息被丢弃了，但还知道它的Seq，可以回复一个错误
+		if errors.Is(err, coder.ErrMsgTooLarge) {
+			log.Println("RPC server: read request err:", err)
+			return &header, err
+		}
 		if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
 			log.Println("RPC server: read header err:", err)
 		}
@@ -175,15 +396,33 @@ func (s *Server) readRequestHeader(cc coder.Coder) (*coder.Header, error) {
 func (s *Server) readRequest(cc coder.Coder) (*request, error) {
 	header, err := s.readRequestHeader(cc)
 	if err != nil {
+		if header != nil {
+			return &request{header: header}, err
+		}
 		return nil, err
 	}
 	req := &request{header: header}
+	switch header.Kind {
+	case coder.KindCancel, coder.KindGoAway, coder.KindCloseSend:
+		// 控制消息只有header，丢弃它的body
+		return req, cc.ReadBody(nil)
+	case coder.KindStream:
+		// 流上的消息由方法通过stream.Recv解码
+		req.raw = new(coder.RawBody)
+		return req, cc.ReadBody(req.raw)
+	}
 	req.svc, req.mType, err = s.findService(header.ServiceMethod)
 	if err != nil {
 		return req, err
 	}
+	// 客户端流方法没有参数，请求都从stream里读取
+	if req.mType.recvStream {
+		return req, cc.ReadBody(nil)
+	}
 	req.argv = req.mType.newArgv()
-	req.replyv = req.mType.newReplyv()
+	if !req.mType.streaming {
+		req.replyv = req.mType.newReplyv()
+	}
 
 	// make sure argv is a pointer type, or it will panic
 	argvi := req.argv.Interface()
@@ -192,52 +431,188 @@ func (s *Server) readRequest(cc coder.Coder) (*request, error) {
 	}
 	if err = cc.ReadBody(argvi); err != nil {
 		log.Println("RPC server: read argv err:", err)
-		return req, err
+		return req, status.Errorf(status.InvalidArgument, "rpc server: read argv: %v", err)
 	}
 	return req, nil
 }
 
-func (s *Server) sendResponse(cc coder.Coder, header *coder.Header, body interface{}, sending *sync.Mutex) {
-	// 同时有响应err和正确的方法call，所以需要轮流发，一面消息粘在一起
-	sending.Lock()
-	defer sending.Unlock()
+func (s *Server) sendResponse(cc coder.Coder, header *coder.Header, body interface{}, sending *sync.RWMutex) {
+	// 响应可以同时发送，大的响应会被分成多个帧，和其他响应交替发送；
+	// 只有go away消息需要等正在发送的响应
+	sending.RLock()
+	defer sending.RUnlock()
 	if err := cc.Write(header, body); err != nil {
 		log.Println("RPC server: write response err:", err)
+		// 响应太大时什么都没有发送，改为发送一个错误
+		if errors.Is(err, coder.ErrMsgTooLarge) {
+			h := &coder.Header{ServiceMethod: header.ServiceMethod, Seq: header.Seq, Metadata: header.Metadata}
+			setError(h, sendError(err))
+			_ = cc.Write(h, invalidRequest)
+		}
 	}
 }
 
-func (s *Server) handleRequest(cc coder.Coder, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
-	defer wg.Done()
-	called := make(chan struct{})
-	sent := make(chan struct{})
-	go func() {
-		err := req.svc.call(req.mType, req.argv, req.replyv)
+// errCanceledByClient is the cause of a request context cancelled by the client
+var errCanceledByClient = errors.New("rpc server: call canceled by client")
 
-		called <- struct{}{}
-		if err != nil {
-			req.header.Error = err.Error()
-			s.sendResponse(cc, req.header, invalidRequest, sending)
-			sent <- struct{}{}
+// inflightRequests 记录一个连接上正在处理的请求，客户端取消时找到对应的请求
+type inflightRequests struct {
+	mu      sync.Mutex
+	cancels map[uint64]context.CancelCauseFunc
+	streams map[uint64]*ServerStream // streams receiving messages from the client
+}
+
+func newInflightRequests() *inflightRequests {
+	return &inflightRequests{
+		cancels: make(map[uint64]context.CancelCauseFunc),
+		streams: make(map[uint64]*ServerStream),
+	}
+}
+
+// addStream routes the messages with seq to st until the request finishes
+func (r *inflightRequests) addStream(seq uint64, st *ServerStream) {
+	r.mu.Lock()
+	r.streams[seq] = st
+	r.mu.Unlock()
+}
+
+// stream returns the stream of the request with seq, nil if there is none
+func (r *inflightRequests) stream(seq uint64) *ServerStream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.streams[seq]
+}
+
+// add derives a cancelable context for the request with seq, done must be called when the request finishes
+func (r *inflightRequests) add(ctx context.Context, seq uint64) (_ context.Context, done func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	r.mu.Lock()
+	r.cancels[seq] = cancel
+	r.mu.Unlock()
+	return ctx, func() {
+		r.mu.Lock()
+		st := r.streams[seq]
+		delete(r.cancels, seq)
+		delete(r.streams, seq)
+		r.mu.Unlock()
+		if st != nil {
+			st.drop()
+		}
+		cancel(nil)
+	}
+}
+
+// cancel cancels the request with seq, it does nothing if the request has finished
+func (r *inflightRequests) cancel(seq uint64) {
+	r.mu.Lock()
+	cancel := r.cancels[seq]
+	r.mu.Unlock()
+	if cancel != nil {
+		cancel(errCanceledByClient)
+	}
+}
+
+// handleRequest 执行请求并且只发送一个响应。超时后不再等待方法返回，
+// 方法所在的goroutine返回后结果被丢弃，不会阻塞也不会再发送响应
+// ctx和done由inflightRequests.add返回
+func (s *Server) handleRequest(ctx context.Context, done func(), sc *serverConn, req *request, wg *sync.WaitGroup, timeout time.Duration) {
+	defer wg.Done()
+	// 客户端取消、超时或handleRequest返回时取消ctx，通知还在运行的方法和拦截器
+	defer done()
+	// 客户端的deadline和服务端的HandleTimeout，以先到的为准
+	if req.header.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.header.Timeout)
+		defer cancel()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// 响应用新的header，方法可能还在读req.header
+	header := &coder.Header{ServiceMethod: req.header.ServiceMethod, Seq: req.header.Seq}
+	// 自适应限流在排队之前拒绝，延迟包括排队的时间
+	releaseAdaptive := func() {}
+	if s.adaptive != nil && !req.mType.streaming {
+		if !s.adaptive.acquire() {
+			setError(header, ErrOverloaded)
+			s.sendResponse(sc.cc, header, invalidRequest, &sc.sending)
+			return
+		}
+		start := time.Now()
+		releaseAdaptive = func() {
+			// 客户端取消或者连接断开的请求不算延迟
+			s.adaptive.release(time.Since(start), ctx.Err() != context.Canceled)
+		}
+	}
+	// 超过并发限制时排队等待，等不到名额就拒绝
+	release, err := s.admit(ctx, sc.sem, req.mType.sem, s.sem)
+	if err != nil {
+		releaseAdaptive()
+		if ctx.Err() == nil {
+			setError(header, err)
+			s.sendResponse(sc.cc, header, invalidRequest, &sc.sending)
 			return
 		}
-		s.sendResponse(cc, req.header, req.replyv.Interface(), sending)
-		sent <- struct{}{}
-	}()
-
-	// 如果没有设置超时，那么就直接返回
-	if timeout == 0 {
-		<-called
-		<-sent
+		s.sendTimeout(ctx, sc, header)
 		return
 	}
 
+	ctx, respMD := newRequestContext(ctx, req.header.Metadata)
+	if req.mType.streaming {
+		req.replyv.Interface().(*ServerStream).ctx = ctx
+	}
+	// 客户端流方法没有参数
+	var argv interface{}
+	if req.argv.IsValid() {
+		argv = req.argv.Interface()
+	}
+	// 带缓冲，超时后没人接收，方法返回时也不会阻塞
+	called := make(chan error, 1)
+	s.run(func() {
+		// 超时之后方法还在运行，名额等它返回再释放
+		defer releaseAdaptive()
+		defer release()
+		// 拦截器panic同样只影响这一次调用
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("rpc server: %s interceptor panic: %v\n%s", req.header.ServiceMethod, r, stack())
+				called <- status.Errorf(status.Panic, "rpc server: %s interceptor panic: %v", req.header.ServiceMethod, r)
+			}
+		}()
+		called <- s.handler(req)(ctx, req.header, argv, req.replyv.Interface())
+	})
+
 	select {
-	// timeout结束，call还没有接收到数据，直接sendResponse
-	case <-time.After(timeout):
-		req.header.Error = "rpc server: handle request timeout"
-		s.sendResponse(cc, req.header, invalidRequest, sending)
-	// call接收到数据，说明已经发送了，直接返回
-	case <-called:
-		<-sent
+	case err := <-called:
+		if context.Cause(ctx) == errCanceledByClient {
+			return
+		}
+		// 响应只带回服务端设置的metadata
+		header.Metadata = respMD.collect()
+		if err != nil {
+			setError(header, err)
+			s.sendResponse(sc.cc, header, invalidRequest, &sc.sending)
+			return
+		}
+		// 流式方法的响应已经通过stream发送，最后只需要发送状态
+		if req.mType.streaming {
+			s.sendResponse(sc.cc, header, invalidRequest, &sc.sending)
+			return
+		}
+		s.sendResponse(sc.cc, header, req.replyv.Interface(), &sc.sending)
+	case <-ctx.Done():
+		s.sendTimeout(ctx, sc, header)
+	}
+}
+
+// sendTimeout 请求的ctx结束时，只有超时需要响应；
+// 客户端已经放弃了这个call，或者连接已经断开，不需要响应
+func (s *Server) sendTimeout(ctx context.Context, sc *serverConn, header *coder.Header) {
+	if ctx.Err() != context.DeadlineExceeded {
+		return
 	}
+	setError(header, status.New(status.DeadlineExceeded, "rpc server: handle request timeout"))
+	s.sendResponse(sc.cc, header, invalidRequest, &sc.sending)
 }
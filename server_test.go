@@ -0,0 +1,424 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"geerpc/coder"
+	"geerpc/status"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestServer serves s on a free port and returns its address
+func startTestServer(s *Server) string {
+	l, _ := net.Listen("tcp", ":0")
+	go s.Accept(l)
+	return l.Addr().String()
+}
+
+func TestServer_Use(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	_ = s.Register(&foo)
+
+	var mu sync.Mutex
+	var trace []string
+	record := func(name string) ServerInterceptor {
+		return func(ctx context.Context, h *coder.Header, argv, reply interface{}, next Handler) error {
+			mu.Lock()
+			trace = append(trace, name+":"+h.ServiceMethod)
+			mu.Unlock()
+			return next(ctx, h, argv, reply)
+		}
+	}
+	s.Use(record("first"), record("second"))
+	s.Use(func(ctx context.Context, h *coder.Header, argv, reply interface{}, next Handler) error {
+		if argv.(Args).Num1 < 0 {
+			return errors.New("negative number")
+		}
+		err := next(ctx, h, argv, reply)
+		*reply.(*int) *= 10
+		return err
+	})
+
+	client, _ := Dial("tcp", startTestServer(s))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 30, "expect reply 30 from interceptor, got %d, err: %v", reply, err)
+	_assert(strings.Join(trace, ",") == "first:Foo.Sum,second:Foo.Sum", "unexpected interceptor order %v", trace)
+
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: -1, Num2: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "negative number"), "expect the call to be short-circuited")
+	_assert(len(trace) == 4, "unexpected trace %v", trace)
+}
+
+func TestServer_metadata(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	_ = s.Register(&foo)
+	s.Use(func(ctx context.Context, h *coder.Header, argv, reply interface{}, next Handler) error {
+		md := MetadataFromContext(ctx)
+		if md["token"] != "secret" || h.Metadata["trace-id"] != md["trace-id"] {
+			return errors.New("unauthorized")
+		}
+		SetResponseMetadata(ctx, Metadata{"trace-id": md["trace-id"], "server": "test"})
+		return next(ctx, h, argv, reply)
+	})
+	addr := startTestServer(s)
+
+	for _, typ := range []coder.Type{coder.GobType, coder.JsonType} {
+		t.Run(string(typ), func(t *testing.T) {
+			client, _ := Dial("tcp", addr, &Option{CoderType: typ})
+			defer func() { _ = client.Close() }()
+
+			var reply int
+			err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+			_assert(err != nil && strings.Contains(err.Error(), "unauthorized"), "expect call without metadata to fail")
+
+			ctx := WithMetadata(context.Background(), Metadata{"token": "secret"})
+			ctx = WithMetadata(ctx, Metadata{"trace-id": "42"})
+			respMD := make(Metadata)
+			err = client.Call(WithResponseMetadata(ctx, respMD), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+			_assert(err == nil && reply == 3, "failed to call with metadata: %v", err)
+			_assert(respMD["trace-id"] == "42" && respMD["server"] == "test" && respMD["token"] == "",
+				"unexpected response metadata %v", respMD)
+		})
+	}
+}
+
+func TestServer_context(t *testing.T) {
+	var baz Baz
+	s := NewServer()
+	_ = s.Register(&baz)
+	cancelled := make(chan error, 1)
+	s.Use(func(ctx context.Context, h *coder.Header, argv, reply interface{}, next Handler) error {
+		err := next(ctx, h, argv, reply)
+		cancelled <- ctx.Err()
+		return err
+	})
+	client, _ := Dial("tcp", startTestServer(s), &Option{HandleTimeout: 100 * time.Millisecond})
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Baz.Wait", 10, &reply)
+	_assert(err == nil && reply == "done", "failed to call Baz.Wait: %v", err)
+	_assert(<-cancelled == nil, "ctx should not be done")
+
+	err = client.Call(context.Background(), "Baz.Wait", 5000, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "handle request timeout"), "expect a timeout error")
+	select {
+	case err = <-cancelled:
+		_assert(err == context.DeadlineExceeded, "expect the method to see the deadline, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("the method is not cancelled by HandleTimeout")
+	}
+}
+
+func TestServer_clientCancel(t *testing.T) {
+	var baz Baz
+	s := NewServer()
+	_ = s.Register(&baz)
+	type result struct {
+		cause       error
+		hasDeadline bool
+	}
+	results := make(chan result, 1)
+	s.Use(func(ctx context.Context, h *coder.Header, argv, reply interface{}, next Handler) error {
+		_, hasDeadline := ctx.Deadline()
+		err := next(ctx, h, argv, reply)
+		results <- result{context.Cause(ctx), hasDeadline}
+		return err
+	})
+	client, _ := Dial("tcp", startTestServer(s))
+	defer func() { _ = client.Close() }()
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		var reply string
+		err := client.Call(ctx, "Baz.Wait", 10, &reply)
+		r := <-results
+		_assert(err == nil && r.hasDeadline, "expect the server to see the deadline of the caller")
+	})
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		var reply string
+		err := client.Call(ctx, "Baz.Wait", 5000, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), context.Canceled.Error()), "expect a canceled error")
+		select {
+		case r := <-results:
+			_assert(r.cause == errCanceledByClient && !r.hasDeadline, "expect the server to be canceled by client, got %v", r.cause)
+		case <-time.After(time.Second):
+			t.Fatal("the server doesn't cancel the request")
+		}
+	})
+	t.Run("cancel at once", func(t *testing.T) {
+		// 取消紧跟着请求到达，服务端可能还没有开始处理请求
+		var reply string
+		call := client.Go("Baz.Wait", 5000, &reply, make(chan *Call, 1))
+		client.sendCancel(call.Seq)
+		defer client.removeCall(call.Seq)
+		select {
+		case r := <-results:
+			_assert(r.cause == errCanceledByClient, "expect the server to be canceled by client, got %v", r.cause)
+		case <-time.After(time.Second):
+			t.Fatal("the cancel sent right after the request is lost")
+		}
+	})
+}
+
+type Slow int
+
+// Sleep ignores ctx and sleeps for ms milliseconds
+func (s Slow) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func TestServer_handleTimeoutLeak(t *testing.T) {
+	var slow Slow
+	s := NewServer()
+	_ = s.Register(&slow)
+	client, _ := Dial("tcp", startTestServer(s), &Option{HandleTimeout: 20 * time.Millisecond})
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_ = client.Call(context.Background(), "Slow.Sleep", 0, &reply)
+	base := runtime.NumGoroutine()
+
+	const n = 50
+	var wg sync.WaitGroup
+	var timeouts int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			err := client.Call(context.Background(), "Slow.Sleep", 100, &reply)
+			if err != nil && strings.Contains(err.Error(), "handle request timeout") {
+				atomic.AddInt32(&timeouts, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	_assert(timeouts == n, "expect %d timeouts, got %d", n, timeouts)
+
+	// 等到所有方法都返回，goroutine的数量应该回到原来的水平
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > base && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(runtime.NumGoroutine() <= base, "goroutines leaked: %d before, %d after", base, runtime.NumGoroutine())
+
+	// 超时的请求不会再收到第二个响应，连接仍然正常
+	err := client.Call(context.Background(), "Slow.Sleep", 1, &reply)
+	_assert(err == nil && reply == 1, "failed to call after timeouts: %v", err)
+}
+
+// Panic panics with msg
+func (s Slow) Panic(msg string, reply *int) error {
+	panic(msg)
+}
+
+func TestServer_panic(t *testing.T) {
+	var slow Slow
+	s := NewServer()
+	_ = s.Register(&slow)
+	client, _ := Dial("tcp", startTestServer(s))
+	defer func() { _ = client.Close() }()
+
+	// 另一个请求在panic的同时处理中，不受影响
+	var slept int
+	sleep := client.Go("Slow.Sleep", 50, &slept, make(chan *Call, 1))
+	var reply int
+	err := client.Call(context.Background(), "Slow.Panic", "boom", &reply)
+	_assert(errors.Is(err, status.Panic) && strings.Contains(err.Error(), "boom"), "expect a Panic status, got %v", err)
+	call := <-sleep.Done
+	_assert(call.Error == nil && slept == 50, "in-flight call failed: %v", call.Error)
+
+	svc, mType, _ := s.findService("Slow.Panic")
+	_assert(svc != nil && mType.NumPanics() == 1 && mType.NumCalls() == 1, "expect the panic to be counted")
+	err = client.Call(context.Background(), "Slow.Sleep", 1, &reply)
+	_assert(err == nil && reply == 1, "failed to call after panic: %v", err)
+}
+
+func TestServer_status(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	_ = s.Register(&foo)
+	s.Use(func(ctx context.Context, h *coder.Header, argv, reply interface{}, next Handler) error {
+		if argv.(Args).Num1 < 0 {
+			return status.New(status.InvalidArgument, "negative number").WithDetails("Num1")
+		}
+		return next(ctx, h, argv, reply)
+	})
+	client, _ := Dial("tcp", startTestServer(s))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Foo.Missing", &Args{}, &reply)
+	_assert(errors.Is(err, status.NotFound), "expect NotFound, got %v", err)
+	err = client.Call(context.Background(), "Foo", &Args{}, &reply)
+	_assert(status.CodeOf(err) == status.InvalidArgument, "expect InvalidArgument, got %v", err)
+
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: -1}, &reply)
+	st, ok := status.FromError(err)
+	_assert(ok && st.Code == status.InvalidArgument && st.Message == "negative number" && len(st.Details) == 1 && st.Details[0] == "Num1",
+		"expect the status to round-trip, got %v", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = client.Call(ctx, "Foo.Sum", &Args{}, &reply)
+	_assert(errors.Is(err, status.Canceled) && errors.Is(err, context.Canceled), "expect Canceled, got %v", err)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	var slow Slow
+	s := NewServer()
+	_ = s.Register(&slow)
+	addr := startTestServer(s)
+
+	t.Run("drain", func(t *testing.T) {
+		client, _ := Dial("tcp", addr)
+		defer func() { _ = client.Close() }()
+		idle, _ := Dial("tcp", addr)
+		defer func() { _ = idle.Close() }()
+
+		var reply int
+		call := client.Go("Slow.Sleep", 200, &reply, make(chan *Call, 1))
+		time.Sleep(50 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		err := s.Shutdown(ctx)
+		_assert(err == nil, "failed to shut down: %v", err)
+		call = <-call.Done
+		_assert(call.Error == nil && reply == 200, "in-flight call failed: %v", call.Error)
+
+		err = client.Call(context.Background(), "Slow.Sleep", 1, &reply)
+		_assert((err == ErrShutdown || err == ErrGoAway) && !client.IsAvailable(), "expect the client to be unavailable after shutdown, got %v", err)
+		_, err = Dial("tcp", addr)
+		_assert(err != nil, "expect the server to stop accepting")
+	})
+	t.Run("force", func(t *testing.T) {
+		s := NewServer()
+		_ = s.Register(&slow)
+		client, _ := Dial("tcp", startTestServer(s))
+		defer func() { _ = client.Close() }()
+
+		var reply int
+		call := client.Go("Slow.Sleep", 1000, &reply, make(chan *Call, 1))
+		time.Sleep(50 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err := s.Shutdown(ctx)
+		_assert(err == context.DeadlineExceeded, "expect the deadline to be exceeded, got %v", err)
+		call = <-call.Done
+		_assert(call.Error != nil, "expect the call to fail when the connection is closed")
+	})
+}
+
+type Echo int
+
+// Repeat replies s repeated n times
+func (e Echo) Repeat(args RepeatArgs, reply *string) error {
+	*reply = strings.Repeat(args.S, args.N)
+	return nil
+}
+
+type RepeatArgs struct {
+	S string
+	N int
+}
+
+func TestServer_maxMsgSize(t *testing.T) {
+	var echo Echo
+	s := NewServer()
+	_ = s.Register(&echo)
+	addr := startTestServer(s)
+
+	call := func(client *Client, args RepeatArgs) (string, error) {
+		var reply string
+		err := client.Call(context.Background(), "Echo.Repeat", args, &reply)
+		return reply, err
+	}
+	t.Run("recv", func(t *testing.T) {
+		client, _ := Dial("tcp", addr, &Option{MaxRecvMsgSize: 1 << 10})
+		defer func() { _ = client.Close() }()
+		// 请求太大，服务端回复错误，连接仍然可用
+		_, err := call(client, RepeatArgs{S: strings.Repeat("x", 4<<10), N: 1})
+		_assert(errors.Is(err, status.ResourceExhausted), "expect ResourceExhausted for a large request, got %v", err)
+		// 响应太大，客户端丢弃它
+		_, err = call(client, RepeatArgs{S: "x", N: 4 << 10})
+		_assert(errors.Is(err, status.ResourceExhausted), "expect ResourceExhausted for a large reply, got %v", err)
+		reply, err := call(client, RepeatArgs{S: "x", N: 3})
+		_assert(err == nil && reply == "xxx", "failed to call after large messages: %v", err)
+	})
+	t.Run("send", func(t *testing.T) {
+		client, _ := Dial("tcp", addr, &Option{MaxSendMsgSize: 1 << 10, MaxRecvMsgSize: 64 << 10})
+		defer func() { _ = client.Close() }()
+		_, err := call(client, RepeatArgs{S: strings.Repeat("x", 4<<10), N: 1})
+		_assert(errors.Is(err, status.ResourceExhausted), "expect ResourceExhausted for a large request, got %v", err)
+		// 服务端的响应也受同样的限制
+		_, err = call(client, RepeatArgs{S: "x", N: 4 << 10})
+		_assert(errors.Is(err, status.ResourceExhausted), "expect ResourceExhausted for a large reply, got %v", err)
+		reply, err := call(client, RepeatArgs{S: "x", N: 3})
+		_assert(err == nil && reply == "xxx", "failed to call after large messages: %v", err)
+	})
+	t.Run("server limits", func(t *testing.T) {
+		// 客户端放宽限制，服务端仍然按自己的限制拒绝
+		client, _ := Dial("tcp", addr, &Option{MaxRecvMsgSize: 1 << 30})
+		defer func() { _ = client.Close() }()
+		_, err := call(client, RepeatArgs{S: strings.Repeat("x", coder.DefaultMaxRecvMsgSize+1)})
+		_assert(errors.Is(err, status.ResourceExhausted), "expect ResourceExhausted above the default limit, got %v", err)
+
+		s := NewServer(&ServerOption{MaxRecvMsgSize: 1 << 10})
+		_ = s.Register(&echo)
+		client, _ = Dial("tcp", startTestServer(s), &Option{MaxRecvMsgSize: 1 << 20})
+		defer func() { _ = client.Close() }()
+		_, err = call(client, RepeatArgs{S: strings.Repeat("x", 4<<10), N: 1})
+		_assert(errors.Is(err, status.ResourceExhausted), "expect ResourceExhausted for a large request, got %v", err)
+		_, err = call(client, RepeatArgs{S: "x", N: 4 << 10})
+		_assert(errors.Is(err, status.ResourceExhausted), "expect the reply to be limited too, got %v", err)
+		reply, err := call(client, RepeatArgs{S: "x", N: 3})
+		_assert(err == nil && reply == "xxx", "failed to call after large messages: %v", err)
+	})
+}
+
+func TestServer_errorsWithLargeReplies(t *testing.T) {
+	var echo Echo
+	s := NewServer()
+	_ = s.Register(&echo)
+	client, _ := Dial("tcp", startTestServer(s))
+	defer func() { _ = client.Close() }()
+
+	// 大的响应用完连接的额度时，错误响应也不能卡住读取连接的goroutine
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				var reply string
+				if i%2 == 0 {
+					err := client.Call(ctx, "Echo.Repeat", RepeatArgs{S: "x", N: 3 << 20}, &reply)
+					_assert(err == nil && len(reply) == 3<<20, "failed to get a large reply: %v", err)
+				} else {
+					err := client.Call(ctx, "Nope.X", 0, &reply)
+					_assert(errors.Is(err, status.NotFound), "expect NotFound, got %v", err)
+				}
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
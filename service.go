@@ -1,6 +1,8 @@
 package geerpc
 
 import (
+	"context"
+	"geerpc/status"
 	"go/ast"
 	"log"
 	"reflect"
@@ -8,10 +10,25 @@ import (
 )
 
 type methodType struct {
-	method    reflect.Method
-	ArgvType  reflect.Type
-	ReplyType reflect.Type
-	numCalls  uint64
+	method     reflect.Method
+	ArgvType   reflect.Type
+	ReplyType  reflect.Type
+	numCalls   uint64
+	numPanics  uint64
+	hasContext bool          // the first argument is a context.Context
+	streaming  bool          // the reply is sent through a *ServerStream
+	recvStream bool          // the requests are read from the *ServerStream as well, ArgvType is nil
+	sem        chan struct{} // slots of MaxConcurrentRequestsPerMethod
+}
+
+// HasContext 方法的第一个参数是否是context.Context
+func (m *methodType) HasContext() bool {
+	return m.hasContext
+}
+
+// NumPanics 返回panic的次数
+func (m *methodType) NumPanics() uint64 {
+	return atomic.LoadUint64(&m.numPanics)
 }
 
 // NumCalls 返回调用次数
@@ -65,29 +82,53 @@ func NewService(receiver interface{}) *service {
 	return s
 }
 
+var (
+	typeOfError        = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext      = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfServerStream = reflect.TypeOf((*ServerStream)(nil))
+)
+
 func (s *service) registerMethods() {
 	s.method = make(map[string]*methodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
 		mType := method.Type
+		// 只有一个*ServerStream参数的是客户端流或双向流方法，请求通过stream.Recv读取
+		if mType.NumIn() == 2 && mType.In(1) == typeOfServerStream && mType.NumOut() == 1 && mType.Out(0) == typeOfError {
+			s.method[method.Name] = &methodType{
+				method:     method,
+				ReplyType:  typeOfServerStream,
+				streaming:  true,
+				recvStream: true,
+			}
+			log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
+			continue
+		}
 		// 一个方法必须有三个参数，第一个参数是receiver，第二个参数是argv，第三个参数是reply
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		// 也可以在argv前面多一个context.Context参数，reply是*ServerStream时可以发送多个响应
+		if (mType.NumIn() != 3 && mType.NumIn() != 4) || mType.NumOut() != 1 {
+			continue
+		}
+		hasContext := mType.NumIn() == 4
+		if hasContext && mType.In(1) != typeOfContext {
 			continue
 		}
 		// 返回值必须是error类型
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		if mType.Out(0) != typeOfError {
 			continue
 		}
-		// 第二个参数和第三个参数必须是导出或内置类型
-		argType, replyType := mType.In(1), mType.In(2)
+		// 最后两个参数必须是导出或内置类型
+		argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
 		// 新建一个methodType，存储方法的信息
 		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgvType:  argType,
-			ReplyType: replyType,
+			method:     method,
+			ArgvType:   argType,
+			ReplyType:  replyType,
+			hasContext: hasContext,
+			streaming:  replyType == typeOfServerStream,
 		}
 		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
@@ -96,11 +137,25 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) (err error) {
 	atomic.AddUint64(&m.numCalls, 1)
+	// 方法panic只影响这一次调用，不能让整个服务端崩溃
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&m.numPanics, 1)
+			log.Printf("rpc server: %s.%s panic: %v\n%s", s.name, m.method.Name, r, stack())
+			err = status.Errorf(status.Panic, "rpc server: %s.%s panic: %v", s.name, m.method.Name, r)
+		}
+	}()
 	f := m.method.Func
+	in := []reflect.Value{s.receiver, argv, replyv}
+	if m.recvStream {
+		in = []reflect.Value{s.receiver, replyv}
+	} else if m.hasContext {
+		in = []reflect.Value{s.receiver, reflect.ValueOf(ctx), argv, replyv}
+	}
 	// 调用方法
-	retValues := f.Call([]reflect.Value{s.receiver, argv, replyv})
+	retValues := f.Call(in)
 	// 返回值的第一个是error, 如果不为nil，就返回
 	if errInter := retValues[0].Interface(); errInter != nil {
 		return errInter.(error)
@@ -1,9 +1,11 @@
 package geerpc
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type Foo int
@@ -43,11 +45,39 @@ func TestMethodType_Call(t *testing.T) {
 	argv := mType.newArgv()
 	replyv := mType.newReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyv)
+	err := s.call(context.Background(), mType, argv, replyv)
 	// 检查是否调用成功 以及 返回值是否正确 以及 调用次数是否正确
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
 
+type Baz int
+
+// Wait blocks until ctx is done, and reports why through reply
+func (b Baz) Wait(ctx context.Context, timeout int, reply *string) error {
+	select {
+	case <-ctx.Done():
+		*reply = ctx.Err().Error()
+		return ctx.Err()
+	case <-time.After(time.Duration(timeout) * time.Millisecond):
+		*reply = "done"
+		return nil
+	}
+}
+
+func TestNewService_context(t *testing.T) {
+	var baz Baz
+	s := NewService(&baz)
+	mType := s.method["Wait"]
+	_assert(mType != nil && mType.HasContext(), "failed to register method Wait with context")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	argv, replyv := mType.newArgv(), mType.newReplyv()
+	argv.Set(reflect.ValueOf(1000))
+	err := s.call(ctx, mType, argv, replyv)
+	_assert(err == context.Canceled && *replyv.Interface().(*string) == err.Error(), "expect ctx to reach the method")
+}
+
 //func startServer(addr chan string) {
 //	//var foo Foo
 //	//if err := Register(&foo); err != nil {
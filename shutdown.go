@@ -0,0 +1,134 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/coder"
+	"log"
+	"net"
+	"sync"
+)
+
+// serverConn 保存一个连接上的状态，Shutdown通过它通知客户端并等待请求处理完
+type serverConn struct {
+	cc      coder.Coder
+	sending sync.RWMutex // held for reading while sending a response
+	ctx     context.Context
+	cancel  context.CancelFunc // 取消连接上所有请求的ctx
+	done    chan struct{}      // closed when the connection is closed
+	sem     chan struct{}      // slots of MaxConcurrentRequestsPerConn
+}
+
+func newServerConn(cc coder.Coder, p *Peer, limit int) *serverConn {
+	// 连接上所有请求的ctx都带着peer
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), peerKey{}, p))
+	return &serverConn{
+		cc:     cc,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		sem:    newLimit(limit),
+	}
+}
+
+// goAway tells the client to stop sending new requests,
+// the client acknowledges it with a KindGoAway message as its last one
+func (sc *serverConn) goAway() {
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	h := &coder.Header{Kind: coder.KindGoAway}
+	if err := sc.cc.Write(h, invalidRequest); err != nil {
+		log.Println("rpc server: send go away err:", err)
+	}
+}
+
+// close cancels the in-flight requests and closes the connection right away
+func (sc *serverConn) close() {
+	sc.cancel()
+	_ = sc.cc.Close()
+}
+
+// trackListener adds or removes a listener closed by Shutdown,
+// it returns false if the server is shutting down
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.inShutdown {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// trackConn adds or removes a connection drained by Shutdown,
+// it returns false if the server is shutting down
+func (s *Server) trackConn(sc *serverConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, sc)
+		return true
+	}
+	if s.inShutdown {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*serverConn]struct{})
+	}
+	s.conns[sc] = struct{}{}
+	return true
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inShutdown
+}
+
+// Shutdown gracefully shuts down the server: it stops accepting connections,
+// tells the connected clients to stop sending new requests, and waits for the
+// in-flight requests to finish before closing the connections. If ctx is done
+// first, the remaining connections are closed at once and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.pool != nil {
+		defer s.pool.stop()
+	}
+	s.mu.Lock()
+	s.inShutdown = true
+	for l := range s.listeners {
+		_ = l.Close()
+		delete(s.listeners, l)
+	}
+	conns := make([]*serverConn, 0, len(s.conns))
+	for sc := range s.conns {
+		conns = append(conns, sc)
+	}
+	s.mu.Unlock()
+
+	for _, sc := range conns {
+		sc.goAway()
+	}
+	for i, sc := range conns {
+		select {
+		case <-sc.done:
+		case <-ctx.Done():
+			// 超时了，强制关闭剩下的连接
+			for _, sc := range conns[i:] {
+				sc.close()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Shutdown is a convenient approach for default server to shut down gracefully
+func Shutdown(ctx context.Context) error {
+	return DefaultServer.Shutdown(ctx)
+}
@@ -0,0 +1,154 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Code is the status code of an RPC, it is an error itself so that
+// errors.Is(err, status.NotFound) tells the code of err
+type Code uint32
+
+const (
+	OK                 Code = iota // not an error
+	Canceled                       // the call was canceled by the caller
+	Unknown                        // the error has no known code
+	InvalidArgument                // the request is ill-formed or its args can't be decoded
+	DeadlineExceeded               // the call didn't finish before its deadline
+	NotFound                       // the service or the method doesn't exist
+	AlreadyExists                  // the entity to create already exists
+	PermissionDenied               // the caller isn't allowed to call the method
+	ResourceExhausted              // some resource, such as message size or quota, has been exhausted
+	FailedPrecondition             // the system isn't in a state required by the call
+	Aborted                        // the call was aborted, typically due to a concurrency issue
+	OutOfRange                     // the call was attempted past the valid range
+	Unimplemented                  // the call isn't implemented or supported
+	Internal                       // an invariant of the system is broken
+	Unavailable                    // the server or the connection is unavailable, the call can be retried
+	DataLoss                       // unrecoverable data loss or corruption
+	Unauthenticated                // the caller has no valid credentials
+	Panic                          // the service method panicked, the server recovered from it
+)
+
+var codeNames = [...]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+	Panic:              "Panic",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+func (c Code) Error() string {
+	return "rpc error: code = " + c.String()
+}
+
+// Status is an RPC error with a code, a message and optional details
+type Status struct {
+	Code    Code
+	Message string
+	Details []string
+}
+
+// New returns a Status with the given code and message
+func New(code Code, msg string) *Status {
+	return &Status{Code: code, Message: msg}
+}
+
+// Errorf returns a Status with the given code and a formatted message
+func Errorf(code Code, format string, a ...interface{}) *Status {
+	return New(code, fmt.Sprintf(format, a...))
+}
+
+// WithDetails returns a copy of s with details appended
+func (s *Status) WithDetails(details ...string) *Status {
+	out := *s
+	out.Details = append(append([]string(nil), s.Details...), details...)
+	return &out
+}
+
+func (s *Status) Error() string {
+	msg := fmt.Sprintf("rpc error: code = %s desc = %s", s.Code.String(), s.Message)
+	if len(s.Details) > 0 {
+		msg += " details = [" + strings.Join(s.Details, ", ") + "]"
+	}
+	return msg
+}
+
+// Is reports whether s matches target: a Code matches by code, a Status matches
+// by code and message, and the context errors match their codes
+func (s *Status) Is(target error) bool {
+	switch t := target.(type) {
+	case Code:
+		return s.Code == t
+	case *Status:
+		return s.Code == t.Code && s.Message == t.Message
+	}
+	switch target {
+	case context.Canceled:
+		return s.Code == Canceled
+	case context.DeadlineExceeded:
+		return s.Code == DeadlineExceeded
+	}
+	return false
+}
+
+// FromError returns the Status in the chain of err
+func FromError(err error) (*Status, bool) {
+	var s *Status
+	if errors.As(err, &s) {
+		return s, true
+	}
+	return nil, false
+}
+
+// Convert returns the Status in the chain of err, or wraps err in a new Status,
+// the context errors get their own codes and any other error gets Unknown
+func Convert(err error) *Status {
+	if err == nil {
+		return nil
+	}
+	if s, ok := FromError(err); ok {
+		return s
+	}
+	var code Code
+	if errors.As(err, &code) {
+		return New(code, err.Error())
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return New(Canceled, err.Error())
+	}
+	return New(Unknown, err.Error())
+}
+
+// CodeOf returns the code of err, OK if err is nil
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	return Convert(err).Code
+}
@@ -0,0 +1,31 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", Errorf(NotFound, "can't find %s", "Foo"))
+	if !errors.Is(err, NotFound) || errors.Is(err, Internal) {
+		t.Fatal("expect err to match NotFound only")
+	}
+	if st, ok := FromError(err); !ok || st.Message != "can't find Foo" {
+		t.Fatalf("unexpected status %v", st)
+	}
+	if got, want := Errorf(NotFound, "can't find Foo").WithDetails("a", "b").Error(),
+		"rpc error: code = NotFound desc = can't find Foo details = [a, b]"; got != want {
+		t.Fatalf("expect %q, got %q", want, got)
+	}
+	if CodeOf(nil) != OK || CodeOf(errors.New("x")) != Unknown || CodeOf(context.DeadlineExceeded) != DeadlineExceeded {
+		t.Fatal("unexpected codes from CodeOf")
+	}
+	if !errors.Is(New(DeadlineExceeded, ""), context.DeadlineExceeded) {
+		t.Fatal("expect DeadlineExceeded to match the context error")
+	}
+	if Code(100).String() != "Code(100)" || Panic.String() != "Panic" {
+		t.Fatal("unexpected code names")
+	}
+}
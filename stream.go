@@ -0,0 +1,287 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/coder"
+	"geerpc/status"
+	"io"
+	"sync"
+)
+
+// ServerStream sends the replies of a streaming method, whose signature is
+// func (t *T) MethodName(argType T1, stream *geerpc.ServerStream) error
+// or, for a client-side or bidirectional streaming method,
+// func (t *T) MethodName(stream *geerpc.ServerStream) error
+// whose requests are read by Recv. The error returned by the method is sent
+// to the client as the final status.
+type ServerStream struct {
+	ctx     context.Context
+	cc      coder.Coder
+	header  coder.Header // header of the messages sent on the stream
+	sending *sync.RWMutex
+	notify  chan struct{} // 有新的消息到达
+	mu      sync.Mutex    // protect following
+	queue   []*coder.RawBody
+	closed  bool // the client has called CloseSend
+	dropped bool // the method has returned, no one reads the queue any more
+}
+
+// newServerStream 由serveConn创建，ctx在handleRequest里设置好之后方法才会运行
+func newServerStream(cc coder.Coder, h *coder.Header, sending *sync.RWMutex) *ServerStream {
+	return &ServerStream{
+		cc:      cc,
+		header:  coder.Header{ServiceMethod: h.ServiceMethod, Seq: h.Seq, Kind: coder.KindStream},
+		sending: sending,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// Context returns the context of the call, it is done when the call is canceled or timed out
+func (s *ServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// Send sends a reply to the client, it fails once the call is done
+func (s *ServerStream) Send(reply interface{}) error {
+	// 客户端没有及时读取时，等它腾出流的窗口
+	if err := waitWindow(s.ctx, s.cc, s.header.Seq); err != nil {
+		return err
+	}
+	s.sending.RLock()
+	defer s.sending.RUnlock()
+	h := s.header
+	return sendError(s.cc.Write(&h, reply))
+}
+
+// push 由serveConn调用，把客户端发来的消息放进队列，等待Recv解码
+func (s *ServerStream) push(raw *coder.RawBody) {
+	s.mu.Lock()
+	if s.dropped {
+		s.mu.Unlock()
+		raw.Discard()
+		return
+	}
+	s.queue = append(s.queue, raw)
+	s.mu.Unlock()
+	notify(s.notify)
+}
+
+// drop 方法返回后丢弃没有读取的消息，把流量控制的额度还给客户端
+func (s *ServerStream) drop() {
+	s.mu.Lock()
+	queue := s.queue
+	s.queue = nil
+	s.dropped = true
+	s.mu.Unlock()
+	for _, raw := range queue {
+		raw.Discard()
+	}
+}
+
+// closeRecv 客户端调用了CloseSend，队列里的消息读完之后Recv返回io.EOF
+func (s *ServerStream) closeRecv() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	notify(s.notify)
+}
+
+// Recv decodes the next request of a client-side or bidirectional streaming call into
+// args. It returns io.EOF after the client called CloseSend, or the status of the call
+// once it is canceled or timed out. It must not be called concurrently.
+func (s *ServerStream) Recv(args interface{}) error {
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			raw := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			if err := raw.Decode(args); err != nil {
+				return status.Errorf(status.InvalidArgument, "rpc server: decode stream message: %v", err)
+			}
+			return nil
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return io.EOF
+		}
+
+		select {
+		case <-s.notify:
+		case <-s.ctx.Done():
+			return status.Convert(s.ctx.Err())
+		}
+	}
+}
+
+// waitWindow waits until the stream with seq can send another message, the coder
+// without flow control never waits
+func waitWindow(ctx context.Context, cc coder.Coder, seq uint64) error {
+	if err := ctx.Err(); err != nil {
+		return status.Convert(err)
+	}
+	w, ok := cc.(interface {
+		WaitWindow(ctx context.Context, seq uint64) error
+	})
+	if !ok {
+		return nil
+	}
+	if err := w.WaitWindow(ctx, seq); err != nil {
+		if ctx.Err() != nil {
+			return status.Convert(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// notify 唤醒等待新消息的Recv，已经有一个通知没被取走时不再重复通知
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// ClientStream receives the replies of a streaming call, it ends with the final status of the call.
+// For a client-side or bidirectional streaming call it sends the requests as well.
+type ClientStream struct {
+	client     *Client
+	call       *Call
+	ctx        context.Context
+	notify     chan struct{} // 有新的消息到达
+	mu         sync.Mutex    // protect following
+	queue      []*coder.RawBody
+	done       bool  // the final status is received
+	err        error // the final status
+	sendClosed bool  // CloseSend has been called
+}
+
+// Stream invokes a streaming method with args, the replies are read by Recv on the
+// returned stream. Cancel ctx to stop the call before the stream ends.
+func (c *Client) Stream(ctx context.Context, serviceMethod string, args interface{}) (*ClientStream, error) {
+	st := &ClientStream{
+		client: c,
+		ctx:    ctx,
+		notify: make(chan struct{}, 1),
+	}
+	st.call = &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Done:          make(chan *Call, 1),
+		Metadata:      outgoingMetadata(ctx),
+		ctx:           ctx,
+		stream:        st,
+	}
+	st.call.deadline, _ = ctx.Deadline()
+	if err := c.send(st.call); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// NewStream opens a client-side or bidirectional streaming call, the requests are sent
+// by Send and the replies are read by Recv on the returned stream.
+// Cancel ctx to stop the call before the stream ends.
+func (c *Client) NewStream(ctx context.Context, serviceMethod string) (*ClientStream, error) {
+	// 打开流的请求没有参数，服务端会丢弃它的body
+	return c.Stream(ctx, serviceMethod, invalidRequest)
+}
+
+// Send sends args to the streaming method. It returns io.EOF once the call has ended,
+// the final status of the call is then returned by Recv.
+// It can be called concurrently with Recv but not with itself or CloseSend.
+func (s *ClientStream) Send(args interface{}) error {
+	s.mu.Lock()
+	sendClosed := s.sendClosed
+	s.mu.Unlock()
+	if sendClosed {
+		return status.New(status.FailedPrecondition, "rpc client: send after CloseSend")
+	}
+	if s.client.streamOf(s.call.Seq) == nil {
+		return io.EOF
+	}
+	if err := waitWindow(s.ctx, s.client.coder(), s.call.Seq); err != nil {
+		return err
+	}
+	h := &coder.Header{ServiceMethod: s.call.ServiceMethod, Seq: s.call.Seq, Kind: coder.KindStream}
+	return sendError(s.client.write(h, args))
+}
+
+// CloseSend tells the server that no more requests will be sent, the replies can still be
+// read by Recv. Calling it more than once does nothing.
+func (s *ClientStream) CloseSend() error {
+	s.mu.Lock()
+	sendClosed := s.sendClosed
+	s.sendClosed = true
+	s.mu.Unlock()
+	// 调用已经结束时服务端不再需要这个通知
+	if sendClosed || s.client.streamOf(s.call.Seq) == nil {
+		return nil
+	}
+	return s.client.write(&coder.Header{Seq: s.call.Seq, Kind: coder.KindCloseSend}, invalidRequest)
+}
+
+// push 由Client.receive调用，把收到的消息放进队列，等待Recv解码
+func (s *ClientStream) push(raw *coder.RawBody) {
+	s.mu.Lock()
+	s.queue = append(s.queue, raw)
+	s.mu.Unlock()
+	notify(s.notify)
+}
+
+func (s *ClientStream) finishCall(call *Call) {
+	saveResponseMetadata(s.ctx, call.ReplyMetadata)
+	s.finish(call.Error)
+}
+
+func (s *ClientStream) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	s.err = err
+}
+
+// Recv decodes the next reply into reply. After the last reply it returns io.EOF
+// if the call succeeded, or the final status of the call otherwise.
+// It must not be called concurrently.
+func (s *ClientStream) Recv(reply interface{}) error {
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			raw := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			if err := raw.Decode(reply); err != nil {
+				return status.Errorf(status.Internal, "rpc client: decode stream message: %v", err)
+			}
+			return nil
+		}
+		done, err := s.done, s.err
+		s.mu.Unlock()
+		if done {
+			if err == nil {
+				return io.EOF
+			}
+			return err
+		}
+
+		select {
+		case <-s.notify:
+		case call := <-s.call.Done:
+			// 最后的状态一定在所有消息之后到达
+			s.finishCall(call)
+		case <-s.ctx.Done():
+			// call已经被receive取走，最后的状态马上就到
+			if s.client.removeCall(s.call.Seq) == nil {
+				s.finishCall(<-s.call.Done)
+				continue
+			}
+			s.client.sendCancel(s.call.Seq)
+			s.finish(status.Errorf(status.CodeOf(s.ctx.Err()), "rpc client: stream failed: %v", s.ctx.Err()))
+		}
+	}
+}
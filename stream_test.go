@@ -0,0 +1,210 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"geerpc/status"
+	"io"
+	"testing"
+	"time"
+)
+
+type Counter int
+
+// Count sends 0 to n-1 through the stream
+func (c Counter) Count(n int, stream *ServerStream) error {
+	if n < 0 {
+		return status.New(status.InvalidArgument, "negative count")
+	}
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	SetResponseMetadata(stream.Context(), Metadata{"count": "done"})
+	return nil
+}
+
+// Forever sends n until the call is canceled
+func (c Counter) Forever(n int, stream *ServerStream) error {
+	for {
+		if err := stream.Send(n); err != nil {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Sum receives numbers until the client closes its side, then sends their sum
+func (c Counter) Sum(stream *ServerStream) error {
+	sum := 0
+	for {
+		var n int
+		err := stream.Recv(&n)
+		if err == io.EOF {
+			return stream.Send(sum)
+		}
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return status.New(status.InvalidArgument, "negative number")
+		}
+		sum += n
+	}
+}
+
+// Echo sends back every number it receives
+func (c Counter) Echo(stream *ServerStream) error {
+	for {
+		var n int
+		if err := stream.Recv(&n); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := stream.Send(n); err != nil {
+			return err
+		}
+	}
+}
+
+func TestClient_Stream(t *testing.T) {
+	var counter Counter
+	s := NewServer()
+	_ = s.Register(&counter)
+	client, _ := Dial("tcp", startTestServer(s))
+	defer func() { _ = client.Close() }()
+
+	t.Run("count", func(t *testing.T) {
+		md := make(Metadata)
+		st, err := client.Stream(WithResponseMetadata(context.Background(), md), "Counter.Count", 100)
+		_assert(err == nil, "failed to open stream: %v", err)
+		var got []int
+		for {
+			var n int
+			if err = st.Recv(&n); err != nil {
+				break
+			}
+			got = append(got, n)
+		}
+		_assert(err == io.EOF && len(got) == 100 && got[99] == 99, "expect 100 replies then io.EOF, got %d, %v", len(got), err)
+		_assert(md["count"] == "done", "expect the response metadata with the final status")
+	})
+	t.Run("error", func(t *testing.T) {
+		st, _ := client.Stream(context.Background(), "Counter.Count", -1)
+		var n int
+		err := st.Recv(&n)
+		_assert(errors.Is(err, status.InvalidArgument), "expect the final status, got %v", err)
+		_assert(st.Recv(&n) == err, "expect the final status to stay")
+	})
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		st, _ := client.Stream(ctx, "Counter.Forever", 7)
+		var n int
+		for i := 0; i < 10; i++ {
+			err := st.Recv(&n)
+			_assert(err == nil && n == 7, "failed to receive: %v", err)
+		}
+		cancel()
+		var err error
+		for err == nil {
+			err = st.Recv(&n)
+		}
+		_assert(errors.Is(err, context.Canceled), "expect Canceled, got %v", err)
+		// 连接上的其他调用不受影响
+		st, _ = client.Stream(context.Background(), "Counter.Count", 1)
+		_assert(st.Recv(&n) == nil && n == 0 && st.Recv(&n) == io.EOF, "failed to stream after cancel")
+	})
+}
+
+func TestClient_NewStream(t *testing.T) {
+	var counter Counter
+	s := NewServer()
+	_ = s.Register(&counter)
+	client, _ := Dial("tcp", startTestServer(s))
+	defer func() { _ = client.Close() }()
+
+	t.Run("client stream", func(t *testing.T) {
+		st, err := client.NewStream(context.Background(), "Counter.Sum")
+		_assert(err == nil, "failed to open stream: %v", err)
+		for i := 1; i <= 10; i++ {
+			_assert(st.Send(i) == nil, "failed to send")
+		}
+		_assert(st.CloseSend() == nil && st.CloseSend() == nil, "failed to close send")
+		_assert(st.Send(11) != nil, "expect send after CloseSend to fail")
+		var sum int
+		_assert(st.Recv(&sum) == nil && sum == 55, "expect sum 55, got %d", sum)
+		_assert(st.Recv(&sum) == io.EOF, "expect io.EOF after the reply")
+	})
+	t.Run("bidi", func(t *testing.T) {
+		st, _ := client.NewStream(context.Background(), "Counter.Echo")
+		for i := 0; i < 10; i++ {
+			var n int
+			_assert(st.Send(i) == nil, "failed to send")
+			_assert(st.Recv(&n) == nil && n == i, "expect echo %d, got %d", i, n)
+		}
+		_ = st.CloseSend()
+		var n int
+		_assert(st.Recv(&n) == io.EOF, "expect io.EOF after CloseSend")
+		_assert(st.Send(1) != nil, "expect send on a finished stream to fail")
+	})
+	t.Run("error", func(t *testing.T) {
+		st, _ := client.NewStream(context.Background(), "Counter.Sum")
+		_ = st.Send(1)
+		_ = st.Send(-1)
+		var n int
+		err := st.Recv(&n)
+		_assert(errors.Is(err, status.InvalidArgument), "expect the final status, got %v", err)
+		_assert(st.Send(2) == io.EOF, "expect io.EOF when sending on a finished stream")
+	})
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		st, _ := client.NewStream(ctx, "Counter.Echo")
+		var n int
+		_assert(st.Send(1) == nil && st.Recv(&n) == nil && n == 1, "failed to echo")
+		cancel()
+		err := st.Recv(&n)
+		_assert(errors.Is(err, context.Canceled), "expect Canceled, got %v", err)
+		// 连接上的其他调用不受影响
+		var reply int
+		st, _ = client.NewStream(context.Background(), "Counter.Sum")
+		_ = st.Send(3)
+		_ = st.CloseSend()
+		_assert(st.Recv(&reply) == nil && reply == 3, "failed to stream after cancel")
+	})
+}
+
+func TestClient_StreamFlowControl(t *testing.T) {
+	var counter Counter
+	var foo Foo
+	s := NewServer()
+	_ = s.Register(&counter)
+	_ = s.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(s), &Option{WindowSize: 1 << 10})
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st, _ := client.Stream(ctx, "Counter.Forever", 7)
+	// 客户端不读取，服务端用完流的窗口之后就停下来
+	time.Sleep(200 * time.Millisecond)
+	st.mu.Lock()
+	queued := len(st.queue)
+	st.mu.Unlock()
+	_assert(queued > 0 && queued < 100, "expect the stream to be bounded by its window, got %d messages", queued)
+	// 同一个连接上的其他调用不受影响
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call while a stream is blocked: %v", err)
+	// 读取之后服务端可以继续发送
+	var n int
+	for i := 0; i < 2*queued; i++ {
+		_assert(st.Recv(&n) == nil && n == 7, "failed to receive")
+	}
+	cancel()
+	for err == nil {
+		err = st.Recv(&n)
+	}
+	_assert(errors.Is(err, context.Canceled), "expect Canceled, got %v", err)
+}
@@ -0,0 +1,142 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testCA 签发测试用的服务端和客户端证书
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "geerpc test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type Whoami int
+
+// Name replies the common name of the client certificate
+func (w Whoami) Name(ctx context.Context, _ int, reply *string) error {
+	p, ok := PeerFromContext(ctx)
+	if !ok {
+		return errors.New("no peer")
+	}
+	if cert := p.Certificate(); cert != nil {
+		*reply = cert.Subject.CommonName
+	}
+	return nil
+}
+
+func TestServer_AcceptTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}
+	var whoami Whoami
+	s := NewServer()
+	_ = s.Register(&whoami)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.AcceptTLS(l, serverConfig)
+	defer func() { _ = l.Close() }()
+	addr := l.Addr().String()
+
+	clientConfig := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "alice", x509.ExtKeyUsageClientAuth)},
+		RootCAs:      ca.pool,
+	}
+	t.Run("mutual tls", func(t *testing.T) {
+		client, err := XDial("tls@"+addr, &Option{TLSConfig: clientConfig})
+		_assert(err == nil, "failed to dial with tls: %v", err)
+		defer func() { _ = client.Close() }()
+		var name string
+		err = client.Call(context.Background(), "Whoami.Name", 0, &name)
+		_assert(err == nil && name == "alice", "expect the client certificate alice, got %q, %v", name, err)
+	})
+	t.Run("no client certificate", func(t *testing.T) {
+		client, err := Dial("tcp", addr, &Option{TLSConfig: &tls.Config{RootCAs: ca.pool}})
+		if err == nil {
+			// TLS 1.3的客户端在握手之后才知道证书被拒绝
+			var name string
+			err = client.Call(context.Background(), "Whoami.Name", 0, &name)
+			_ = client.Close()
+		}
+		_assert(err != nil, "expect the server to refuse a client without certificate")
+	})
+	t.Run("untrusted server", func(t *testing.T) {
+		_, err := Dial("tcp", addr, &Option{TLSConfig: &tls.Config{}})
+		_assert(err != nil, "expect the client to refuse an untrusted server")
+	})
+	t.Run("plaintext", func(t *testing.T) {
+		_, err := Dial("tcp", addr, &Option{ConnectTimeout: time.Second})
+		_assert(err != nil, "expect a plaintext client to fail")
+	})
+}
+
+func TestDialHTTP_tls(t *testing.T) {
+	ca := newTestCA(t)
+	var whoami Whoami
+	s := NewServer()
+	_ = s.Register(&whoami)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)}}
+	go func() { _ = http.Serve(tls.NewListener(l, serverConfig), s) }()
+	defer func() { _ = l.Close() }()
+
+	client, err := DialHTTP("tcp", l.Addr().String(), &Option{TLSConfig: &tls.Config{RootCAs: ca.pool}})
+	_assert(err == nil, "failed to dial http with tls: %v", err)
+	defer func() { _ = client.Close() }()
+	var name string
+	err = client.Call(context.Background(), "Whoami.Name", 0, &name)
+	_assert(err == nil && name == "", "expect no client certificate, got %q, %v", name, err)
+}
@@ -0,0 +1,246 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"io"
+	"sync"
+	"time"
+)
+
+// PoolOption configures the connections a Pool keeps to each address, zero fields take the defaults
+type PoolOption struct {
+	MinConns int // connections kept open once the address is used, default 0
+	MaxConns int // default 1, or MinConns if it is larger
+	// IdleTimeout closes a connection without pending calls that hasn't been picked
+	// for this long, down to MinConns. 0 keeps the connections open.
+	IdleTimeout time.Duration
+}
+
+// Pool keeps up to MaxConns connections to each address and spreads the calls over them,
+// a call goes to the connection with the fewest pending calls. A new connection is
+// dialed only when every connection is busy.
+type Pool struct {
+	opt     *geerpc.Option
+	popt    PoolOption
+	quit    chan struct{}
+	mu      sync.Mutex // protect following
+	dialed  *sync.Cond // 一个连接建立完成，或者建立失败
+	conns   map[string][]*pooledConn
+	dialing map[string]int // 正在建立的连接数
+	closed  bool
+
+	interceptors []geerpc.ClientInterceptor
+}
+
+// pooledConn 记录连接最后一次被选中的时间
+type pooledConn struct {
+	client   *geerpc.Client
+	lastUsed time.Time
+}
+
+var _ io.Closer = (*Pool)(nil)
+
+// NewPool returns a pool dialing each address with geerpc.XDial and opt
+func NewPool(opt *geerpc.Option, popts ...*PoolOption) *Pool {
+	p := &Pool{
+		opt:     opt,
+		quit:    make(chan struct{}),
+		conns:   make(map[string][]*pooledConn),
+		dialing: make(map[string]int),
+	}
+	p.dialed = sync.NewCond(&p.mu)
+	if len(popts) > 0 && popts[0] != nil {
+		p.popt = *popts[0]
+	}
+	if p.popt.MaxConns < 1 {
+		p.popt.MaxConns = 1
+	}
+	if p.popt.MaxConns < p.popt.MinConns {
+		p.popt.MaxConns = p.popt.MinConns
+	}
+	if p.popt.IdleTimeout > 0 {
+		go p.closeIdle()
+	}
+	return p
+}
+
+// Use appends interceptors to every client of the pool, including the existing ones
+func (p *Pool) Use(interceptors ...geerpc.ClientInterceptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interceptors = append(p.interceptors, interceptors...)
+	for _, conns := range p.conns {
+		for _, pc := range conns {
+			pc.client.Use(interceptors...)
+		}
+	}
+}
+
+// Close closes every connection, the pool can't be used afterwards
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.quit)
+	p.dialed.Broadcast()
+	for rpcAddr, conns := range p.conns {
+		for _, pc := range conns {
+			// ignore err now
+			_ = pc.client.Close()
+		}
+		delete(p.conns, rpcAddr)
+	}
+	return nil
+}
+
+// Get returns the least loaded client of rpcAddr, dialing a new one if they are all busy.
+// The client should be used right away, an idle client may be closed by the pool.
+func (p *Pool) Get(rpcAddr string) (*geerpc.Client, error) {
+	p.mu.Lock()
+	var best *pooledConn
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, geerpc.ErrShutdown
+		}
+		var load int
+		best = nil
+		for _, pc := range p.evict(rpcAddr) {
+			if n := pc.client.Pending(); best == nil || n < load {
+				best, load = pc, n
+			}
+		}
+		n := len(p.conns[rpcAddr]) + p.dialing[rpcAddr]
+		if best != nil && n >= p.popt.MinConns && (load == 0 || n >= p.popt.MaxConns) {
+			best.lastUsed = time.Now()
+			p.mu.Unlock()
+			return best.client, nil
+		}
+		if n < p.popt.MaxConns {
+			break
+		}
+		// 还没有可用的连接，等正在建立的连接
+		p.dialed.Wait()
+	}
+	// 拨号时不持有锁，其他请求可以继续使用已有的连接
+	p.dialing[rpcAddr]++
+	p.mu.Unlock()
+	client, err := geerpc.XDial(rpcAddr, p.opt)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.dialed.Broadcast()
+	if p.dialing[rpcAddr]--; p.dialing[rpcAddr] == 0 {
+		delete(p.dialing, rpcAddr)
+	}
+	if err != nil {
+		// 还有可用的连接时不让调用失败
+		if best != nil && best.client.IsAvailable() {
+			return best.client, nil
+		}
+		return nil, err
+	}
+	if p.closed {
+		_ = client.Close()
+		return nil, geerpc.ErrShutdown
+	}
+	client.Use(p.interceptors...)
+	p.conns[rpcAddr] = append(p.conns[rpcAddr], &pooledConn{client: client, lastUsed: time.Now()})
+	return client, nil
+}
+
+// Call invokes the named function on the least loaded client of rpcAddr
+func (p *Pool) Call(ctx context.Context, rpcAddr, serviceMethod string, args, reply interface{}) error {
+	client, err := p.Get(rpcAddr)
+	if err != nil {
+		return err
+	}
+	return client.Call(ctx, serviceMethod, args, reply)
+}
+
+// evict 移除rpcAddr不可用的连接，返回剩下的连接，调用时持有p.mu
+func (p *Pool) evict(rpcAddr string) []*pooledConn {
+	conns := p.conns[rpcAddr]
+	alive := conns[:0]
+	for _, pc := range conns {
+		if pc.client.IsAvailable() {
+			alive = append(alive, pc)
+			continue
+		}
+		// 服务端正在排空的client还有call没有完成，等它们完成再关闭；
+		// 设置了重连的client不关闭的话会重新连上，之后没有人再用它
+		if pc.client.GoingAway() {
+			go p.closeDrained(pc.client)
+			continue
+		}
+		_ = pc.client.Close()
+	}
+	// 清掉尾部的引用
+	for i := len(alive); i < len(conns); i++ {
+		conns[i] = nil
+	}
+	if len(alive) == 0 {
+		delete(p.conns, rpcAddr)
+		return nil
+	}
+	p.conns[rpcAddr] = alive
+	return alive
+}
+
+// drainPollInterval 检查排空的client是否还有call的间隔
+const drainPollInterval = 20 * time.Millisecond
+
+// closeDrained 等到被移除的client没有未完成的call之后关闭它，pool关闭时直接关闭
+func (p *Pool) closeDrained(client *geerpc.Client) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for client.Pending() > 0 {
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			_ = client.Close()
+			return
+		}
+	}
+	_ = client.Close()
+}
+
+// closeIdle 定期关闭空闲的连接，每个地址至少保留MinConns个
+func (p *Pool) closeIdle() {
+	ticker := time.NewTicker(p.popt.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+		p.mu.Lock()
+		now := time.Now()
+		for rpcAddr := range p.conns {
+			conns := p.evict(rpcAddr)
+			alive := conns[:0]
+			for i, pc := range conns {
+				if len(alive)+len(conns)-i > p.popt.MinConns &&
+					pc.client.Pending() == 0 && now.Sub(pc.lastUsed) > p.popt.IdleTimeout {
+					_ = pc.client.Close()
+					continue
+				}
+				alive = append(alive, pc)
+			}
+			for i := len(alive); i < len(conns); i++ {
+				conns[i] = nil
+			}
+			if len(alive) == 0 {
+				delete(p.conns, rpcAddr)
+			} else {
+				p.conns[rpcAddr] = alive
+			}
+		}
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,135 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func (p *Pool) numConns(rpcAddr string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns[rpcAddr])
+}
+
+// holdAll 通过pool同时发出n个Foo.Hold
+func holdAll(t *testing.T, p *Pool, rpcAddr string, n, ms int) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			if err := p.Call(context.Background(), rpcAddr, "Foo.Hold", ms, &reply); err != nil {
+				t.Error("call failed:", err)
+			}
+		}()
+	}
+	return &wg
+}
+
+func TestPool_leastLoaded(t *testing.T) {
+	_, addr := startServer(t)
+	p := NewPool(nil, &PoolOption{MaxConns: 3})
+	defer func() { _ = p.Close() }()
+
+	wg := holdAll(t, p, addr, 12, 200)
+	time.Sleep(100 * time.Millisecond)
+	p.mu.Lock()
+	conns := append([]*pooledConn(nil), p.conns[addr]...)
+	p.mu.Unlock()
+	if len(conns) != 3 {
+		t.Fatalf("expect 3 connections, got %d", len(conns))
+	}
+	total := 0
+	for _, pc := range conns {
+		n := pc.client.Pending()
+		if n == 0 {
+			t.Fatal("expect every connection to carry calls")
+		}
+		total += n
+	}
+	if total != 12 {
+		t.Fatalf("expect 12 pending calls, got %d", total)
+	}
+	wg.Wait()
+	// 空闲的连接够用，不再建立新的连接
+	holdAll(t, p, addr, 1, 0).Wait()
+	if n := p.numConns(addr); n != 3 {
+		t.Fatalf("expect the connections to be reused, got %d", n)
+	}
+}
+
+func TestPool_evict(t *testing.T) {
+	_, addr := startServer(t)
+	p := NewPool(nil)
+	c1, err := p.Get(addr)
+	if err != nil {
+		t.Fatal("failed to get a client:", err)
+	}
+	_ = c1.Close()
+	c2, err := p.Get(addr)
+	if err != nil || c2 == c1 {
+		t.Fatalf("expect the closed client to be replaced: %v", err)
+	}
+	if n := p.numConns(addr); n != 1 {
+		t.Fatalf("expect 1 connection, got %d", n)
+	}
+	_ = p.Close()
+	if _, err = p.Get(addr); !errors.Is(err, geerpc.ErrShutdown) {
+		t.Fatalf("expect ErrShutdown from a closed pool, got %v", err)
+	}
+}
+
+func TestPool_goAway(t *testing.T) {
+	s, addr := startServer(t)
+	p := NewPool(&geerpc.Option{Reconnect: &geerpc.ReconnectOption{InitialBackoff: 10 * time.Millisecond}})
+	defer func() { _ = p.Close() }()
+	c1, err := p.Get(addr)
+	if err != nil {
+		t.Fatal("failed to get a client:", err)
+	}
+	wg := holdAll(t, p, addr, 1, 200)
+	time.Sleep(50 * time.Millisecond)
+	go func() { _ = s.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	// 排空中的client被pool移除，服务端已经不接受新的连接
+	if _, err = p.Get(addr); err == nil {
+		t.Fatal("expect the dial to fail while the server shuts down")
+	}
+	wg.Wait()
+
+	// 同一个地址上启动新的服务端，被移除的client不会再连上去
+	s2 := geerpc.NewServer()
+	_ = s2.Register(new(Foo))
+	l, err := net.Listen("tcp", strings.TrimPrefix(addr, "tcp@"))
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	go s2.Accept(l)
+	defer func() { _ = s2.Shutdown(context.Background()) }()
+	time.Sleep(100 * time.Millisecond)
+	if c1.IsAvailable() {
+		t.Fatal("expect the evicted client to be closed")
+	}
+}
+
+func TestPool_idle(t *testing.T) {
+	_, addr := startServer(t)
+	p := NewPool(nil, &PoolOption{MinConns: 1, MaxConns: 3, IdleTimeout: 50 * time.Millisecond})
+	defer func() { _ = p.Close() }()
+
+	holdAll(t, p, addr, 6, 50).Wait()
+	if n := p.numConns(addr); n != 3 {
+		t.Fatalf("expect 3 connections, got %d", n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := p.numConns(addr); n != 1 {
+		t.Fatalf("expect the idle connections to be closed down to MinConns, got %d", n)
+	}
+}
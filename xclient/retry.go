@@ -0,0 +1,172 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"geerpc/status"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides whether XClient.Call tries again after an attempt failed,
+// zero fields take the defaults. The zero RetryPolicy never retries.
+type RetryPolicy struct {
+	MaxAttempts    int           // attempts including the first one, 0 or 1 never retries
+	InitialBackoff time.Duration // default 50ms
+	MaxBackoff     time.Duration // default 1s
+	Multiplier     float64       // default 2
+	RetryableCodes []status.Code // default Unavailable
+}
+
+// RetryBudget stops the retries while too many calls fail, so that they don't amplify an
+// outage: a failed attempt takes a token, a successful call gives back Ratio of a token,
+// and retries are allowed while more than half of MaxTokens are left. Zero fields take the defaults.
+type RetryBudget struct {
+	MaxTokens float64 // default 10
+	Ratio     float64 // default 0.1
+}
+
+// RetryOption configures the retries of an XClient. A retry goes to a server that hasn't
+// been tried by the call if there is one, and is given up when the backoff would
+// pass the deadline of the call.
+type RetryOption struct {
+	Default RetryPolicy
+	// Methods overrides Default for "Service.Method" or "Service.*",
+	// an empty RetryPolicy never retries the method
+	Methods map[string]RetryPolicy
+	Budget  RetryBudget
+}
+
+// retrier 按方法找到重试策略，所有方法共用一个预算
+type retrier struct {
+	opt    RetryOption
+	mu     sync.Mutex // protect following
+	tokens float64
+}
+
+func newRetrier(opt *RetryOption) *retrier {
+	if opt == nil {
+		return nil
+	}
+	r := &retrier{opt: *opt}
+	if r.opt.Budget.MaxTokens <= 0 {
+		r.opt.Budget.MaxTokens = 10
+	}
+	if r.opt.Budget.Ratio <= 0 {
+		r.opt.Budget.Ratio = 0.1
+	}
+	r.tokens = r.opt.Budget.MaxTokens
+	return r
+}
+
+// policy returns the policy of serviceMethod with the defaults filled in
+func (r *retrier) policy(serviceMethod string) RetryPolicy {
+	p, ok := r.opt.Methods[serviceMethod]
+	if !ok {
+		service := serviceMethod[:strings.LastIndex(serviceMethod, ".")+1]
+		if p, ok = r.opt.Methods[service+"*"]; !ok || service == "" {
+			p = r.opt.Default
+		}
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 50 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = time.Second
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.RetryableCodes == nil {
+		p.RetryableCodes = []status.Code{status.Unavailable}
+	}
+	return p
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	code := status.CodeOf(err)
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// succeed gives back a part of a token after a successful call
+func (r *retrier) succeed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tokens += r.opt.Budget.Ratio; r.tokens > r.opt.Budget.MaxTokens {
+		r.tokens = r.opt.Budget.MaxTokens
+	}
+}
+
+// fail takes a token for a failed attempt and reports whether the budget allows a retry
+func (r *retrier) fail() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tokens--; r.tokens < 0 {
+		r.tokens = 0
+	}
+	return r.tokens > r.opt.Budget.MaxTokens/2
+}
+
+// backoff 在退避时间上加上±20%的随机，服务端要求的等待时间更长时按服务端的
+func backoff(d time.Duration, err error) time.Duration {
+	d = time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+	if after, ok := geerpc.RetryAfter(err); ok && after > d {
+		d = after
+	}
+	return d
+}
+
+// SetRetryOption sets the retries of Call, nil disables them
+func (xc *XClient) SetRetryOption(opt *RetryOption) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.retry = newRetrier(opt)
+}
+
+func (xc *XClient) retrier() *retrier {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.retry
+}
+
+// callWithRetry 按照serviceMethod的策略重试失败的调用
+func (xc *XClient) callWithRetry(ctx context.Context, r *retrier, serviceMethod string, args, reply interface{}) error {
+	policy := r.policy(serviceMethod)
+	tried := make(map[string]bool)
+	wait := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := xc.try(ctx, serviceMethod, args, reply, tried)
+		if err == nil {
+			r.succeed()
+			return nil
+		}
+		if !policy.retryable(err) || attempt >= policy.MaxAttempts || ctx.Err() != nil || !r.fail() {
+			return err
+		}
+		d := backoff(wait, err)
+		// 等不到下一次尝试就超时了，直接返回这次的错误
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return err
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return status.Errorf(status.CodeOf(ctx.Err()), "rpc client: call failed: %v", ctx.Err())
+		}
+		if wait = time.Duration(float64(wait) * policy.Multiplier); wait > policy.MaxBackoff {
+			wait = policy.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"geerpc/status"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Flaky fails its first Failures calls with Unavailable
+type Flaky struct {
+	Failures int32
+	calls    int32
+}
+
+func (f *Flaky) fail() error {
+	if atomic.AddInt32(&f.calls, 1) <= f.Failures {
+		return status.New(status.Unavailable, "flaky: try again")
+	}
+	return nil
+}
+
+func (f *Flaky) count() int32 {
+	return atomic.LoadInt32(&f.calls)
+}
+
+func (f *Flaky) Get(n int, reply *int) error {
+	*reply = n
+	return f.fail()
+}
+
+func (f *Flaky) Charge(n int, reply *int) error {
+	*reply = n
+	return f.fail()
+}
+
+func startFlakyServer(t *testing.T, f *Flaky) string {
+	s := geerpc.NewServer()
+	_ = s.Register(f)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	go s.Accept(l)
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_retry(t *testing.T) {
+	newXClient := func(t *testing.T, opt *RetryOption, addrs ...string) *XClient {
+		xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, nil)
+		xc.SetRetryOption(opt)
+		t.Cleanup(func() { _ = xc.Close() })
+		return xc
+	}
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	t.Run("attempts", func(t *testing.T) {
+		f := &Flaky{Failures: 2}
+		xc := newXClient(t, &RetryOption{Default: policy}, startFlakyServer(t, f))
+		var reply int
+		if err := xc.Call(context.Background(), "Flaky.Get", 1, &reply); err != nil || reply != 1 {
+			t.Fatalf("expect the third attempt to succeed: %v", err)
+		}
+		f = &Flaky{Failures: 3}
+		xc = newXClient(t, &RetryOption{Default: policy}, startFlakyServer(t, f))
+		if err := xc.Call(context.Background(), "Flaky.Get", 1, &reply); status.CodeOf(err) != status.Unavailable || f.count() != 3 {
+			t.Fatalf("expect 3 attempts to fail, got %d: %v", f.count(), err)
+		}
+	})
+	t.Run("per method", func(t *testing.T) {
+		f := &Flaky{Failures: 1}
+		xc := newXClient(t, &RetryOption{
+			Default: policy,
+			Methods: map[string]RetryPolicy{"Flaky.Charge": {}},
+		}, startFlakyServer(t, f))
+		var reply int
+		if err := xc.Call(context.Background(), "Flaky.Charge", 1, &reply); err == nil || f.count() != 1 {
+			t.Fatalf("expect Flaky.Charge not to be retried, got %d calls: %v", f.count(), err)
+		}
+		if err := xc.Call(context.Background(), "Flaky.Get", 1, &reply); err != nil {
+			t.Fatalf("expect Flaky.Get to succeed: %v", err)
+		}
+	})
+	t.Run("other server", func(t *testing.T) {
+		bad, good := &Flaky{Failures: 100}, &Flaky{}
+		xc := newXClient(t, &RetryOption{Default: RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}},
+			startFlakyServer(t, bad), startFlakyServer(t, good))
+		for i := 0; i < 4; i++ {
+			var reply int
+			if err := xc.Call(context.Background(), "Flaky.Get", i, &reply); err != nil || reply != i {
+				t.Fatalf("expect the retry to go to the other server: %v", err)
+			}
+		}
+		if bad.count() > 4 || good.count() != 4 {
+			t.Fatalf("expect each call to try each server at most once, got %d and %d", bad.count(), good.count())
+		}
+	})
+	t.Run("deadline", func(t *testing.T) {
+		f := &Flaky{Failures: 100}
+		xc := newXClient(t, &RetryOption{Default: RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second}}, startFlakyServer(t, f))
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		var reply int
+		err := xc.Call(ctx, "Flaky.Get", 1, &reply)
+		if status.CodeOf(err) != status.Unavailable || time.Since(start) > 500*time.Millisecond || f.count() != 1 {
+			t.Fatalf("expect no retry past the deadline, got %d calls in %v: %v", f.count(), time.Since(start), err)
+		}
+	})
+	t.Run("budget", func(t *testing.T) {
+		f := &Flaky{Failures: 100}
+		xc := newXClient(t, &RetryOption{
+			Default: RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond},
+			Budget:  RetryBudget{MaxTokens: 4},
+		}, startFlakyServer(t, f))
+		for i := 0; i < 10; i++ {
+			var reply int
+			_ = xc.Call(context.Background(), "Flaky.Get", i, &reply)
+		}
+		// 第一次调用重试一次之后预算就用完了
+		if f.count() != 11 {
+			t.Fatalf("expect the budget to stop the retries after 11 attempts, got %d", f.count())
+		}
+	})
+}
@@ -2,6 +2,7 @@ package xclient
 
 import (
 	"context"
+	"errors"
 	"geerpc"
 	"io"
 	"reflect"
@@ -9,53 +10,38 @@ import (
 )
 
 type XClient struct {
-	d       Discovery
-	mode    SelectMode
-	opt     *geerpc.Option
-	mu      sync.Mutex
-	clients map[string]*geerpc.Client
+	d    Discovery
+	mode SelectMode
+	pool *Pool // 每个服务实例的连接
+
+	mu    sync.Mutex // protect following
+	retry *retrier   // nil表示不重试
 }
 
 var _ io.Closer = (*XClient)(nil)
 
-func NewXClient(d Discovery, mode SelectMode, opt *geerpc.Option) *XClient {
+// NewXClient returns a client of the servers found by d, popts sets the connections
+// kept to each server, one by default
+func NewXClient(d Discovery, mode SelectMode, opt *geerpc.Option, popts ...*PoolOption) *XClient {
 	return &XClient{
-		d:       d,
-		mode:    mode,
-		opt:     opt,
-		clients: make(map[string]*geerpc.Client),
+		d:    d,
+		mode: mode,
+		pool: NewPool(opt, popts...),
 	}
 }
 
+// Use appends interceptors to every client dialed by xc, including the existing ones.
+// Interceptors in opt.Interceptors are passed down to the clients as well.
+func (xc *XClient) Use(interceptors ...geerpc.ClientInterceptor) {
+	xc.pool.Use(interceptors...)
+}
+
 func (xc *XClient) Close() error {
-	xc.mu.Lock()
-	defer xc.mu.Unlock()
-	for key, client := range xc.clients {
-		// ignore err now
-		_ = client.Close()
-		delete(xc.clients, key)
-	}
-	return nil
+	return xc.pool.Close()
 }
 
 func (xc *XClient) dial(rpcAddr string) (*geerpc.Client, error) {
-	xc.mu.Lock()
-	defer xc.mu.Unlock()
-	client, ok := xc.clients[rpcAddr]
-	if ok && !client.IsAvailable() {
-		_ = client.Close()
-		delete(xc.clients, rpcAddr)
-		client = nil
-	}
-	if client == nil {
-		var err error
-		client, err = geerpc.XDial(rpcAddr, xc.opt)
-		if err != nil {
-			return nil, err
-		}
-		xc.clients[rpcAddr] = client
-	}
-	return client, nil
+	return xc.pool.Get(rpcAddr)
 }
 
 func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
@@ -66,12 +52,63 @@ func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod strin
 	return client.Call(ctx, serviceMethod, args, reply)
 }
 
+// Call invokes the named function on a server selected by the Discovery.
+// If the server is going away, is overloaded or can't be dialed, the call moves to
+// another server, which is safe since the request has never been run.
+// The failed calls are retried according to SetRetryOption.
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	if r := xc.retrier(); r != nil {
+		return xc.callWithRetry(ctx, r, serviceMethod, args, reply)
+	}
+	return xc.try(ctx, serviceMethod, args, reply, make(map[string]bool))
+}
+
+// try 在一个还没有试过的服务实例上调用一次，试过的记录在tried里。
+// 所有服务实例都试过时从头开始，重试仍然可以进行
+func (xc *XClient) try(ctx context.Context, serviceMethod string, args, reply interface{}, tried map[string]bool) error {
+	var lastErr error
+	for {
+		rpcAddr, err := xc.pick(tried)
+		if err != nil {
+			return err
+		}
+		if rpcAddr == "" {
+			if lastErr != nil || len(tried) == 0 {
+				return lastErr
+			}
+			for k := range tried {
+				delete(tried, k)
+			}
+			continue
+		}
+		tried[rpcAddr] = true
+		client, err := xc.dial(rpcAddr)
+		if err == nil {
+			err = client.Call(ctx, serviceMethod, args, reply)
+			if !errors.Is(err, geerpc.ErrGoAway) && !errors.Is(err, geerpc.ErrOverloaded) {
+				return err
+			}
+		}
+		lastErr = err
+	}
+}
+
+// pick 按负载均衡策略选择一个还没有试过的服务实例，都试过了返回空字符串
+func (xc *XClient) pick(tried map[string]bool) (string, error) {
 	rpcAddr, err := xc.d.Get(xc.mode)
+	if err != nil || !tried[rpcAddr] {
+		return rpcAddr, err
+	}
+	servers, err := xc.d.GetAll()
 	if err != nil {
-		return err
+		return "", err
+	}
+	for _, rpcAddr := range servers {
+		if !tried[rpcAddr] {
+			return rpcAddr, nil
+		}
 	}
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	return "", nil
 }
 
 func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
@@ -84,6 +121,7 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var e error
 	replyDone := reply == nil // if reply is nil, don't need to set reply
 	ctx, cancelFunc := context.WithCancel(ctx)
+	defer cancelFunc()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {
@@ -0,0 +1,119 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	time.Sleep(time.Millisecond)
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func startServer(t *testing.T) (*geerpc.Server, string) {
+	var foo Foo
+	s := geerpc.NewServer()
+	_ = s.Register(&foo)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	go s.Accept(l)
+	return s, "tcp@" + l.Addr().String()
+}
+
+func TestXClient_goAway(t *testing.T) {
+	s1, addr1 := startServer(t)
+	_, addr2 := startServer(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr1, addr2}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var failures int32
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				var reply int
+				err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: 1}, &reply)
+				if err != nil || reply != i+1 {
+					t.Log("call failed:", err)
+					atomic.AddInt32(&failures, 1)
+				}
+			}
+		}(i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s1.Shutdown(ctx); err != nil {
+		t.Fatal("failed to shut down:", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	if failures != 0 {
+		t.Fatalf("expect calls to move to the other server, %d failed", failures)
+	}
+}
+
+// Hold keeps the server busy for ms milliseconds
+func (f Foo) Hold(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func TestXClient_overloaded(t *testing.T) {
+	var foo Foo
+	s := geerpc.NewServer(&geerpc.ServerOption{
+		AdaptiveLimit: &geerpc.AdaptiveLimitOption{InitialLimit: 1, MinLimit: 1, MaxLimit: 1},
+	})
+	_ = s.Register(&foo)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	go s.Accept(l)
+	addr1 := "tcp@" + l.Addr().String()
+	_, addr2 := startServer(t)
+
+	// 占住第一个服务实例唯一的名额
+	busy, err := geerpc.XDial(addr1)
+	if err != nil {
+		t.Fatal("failed to dial:", err)
+	}
+	defer func() { _ = busy.Close() }()
+	go func() {
+		var reply int
+		_ = busy.Call(context.Background(), "Foo.Hold", 300, &reply)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr1, addr2}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 4; i++ {
+		var reply int
+		if err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: 1}, &reply); err != nil || reply != i+1 {
+			t.Fatalf("expect the call to move to the other server, got %v", err)
+		}
+	}
+}